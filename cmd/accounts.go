@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/processors"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/spf13/cobra"
+)
+
+var discoverSourceFlag int
+var discoverMergeFlag bool
+
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "inspect the accounts of a configured source",
+}
+
+var accountsDiscoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "log in to a source and list its accounts, optionally adding them to the config",
+	// a bank that can't be reached is not a usage problem
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// no point logging in to a bank that can't list them
+		sources := store.GetConfig().Sources
+		if discoverSourceFlag >= 0 && discoverSourceFlag < len(sources) {
+			registration, err := processors.Lookup(sources[discoverSourceFlag].Type)
+			if err == nil && !registration.HasCapability(processors.CapabilityListAccounts) {
+				return fmt.Errorf("%w: %s can not list accounts", core.ErrUnsupportedSource, registration.Type)
+			}
+		}
+
+		source, done, err := OpenSource(cmd, discoverSourceFlag)
+		if err != nil {
+			return err
+		}
+		defer done()
+		item := sources[discoverSourceFlag]
+
+		lister, ok := source.(processors.IAccountProcessor)
+		if !ok {
+			return fmt.Errorf("%w: %s can not list accounts", core.ErrUnsupportedSource, item.Type)
+		}
+		discovered, err := lister.ListAccounts()
+		if err != nil {
+			return err
+		}
+
+		accounts := []store.Account{}
+		core.Header(fmt.Sprintf("Accounts of %02d-%s", discoverSourceFlag, item.Type))
+		for _, account := range discovered {
+			// not every bank shows the type and balance
+			details := []string{account.Name}
+			for _, detail := range []string{account.Type, account.Balance} {
+				if detail != "" {
+					details = append(details, detail)
+				}
+			}
+			core.KeyValue(account.Number, strings.Join(details, ", "))
+			accounts = append(accounts, store.Account{Name: account.Name, Number: account.Number})
+		}
+
+		merged, added := store.MergeAccounts(item.Accounts, accounts)
+		if !discoverMergeFlag {
+			core.KeyValue("not configured yet", len(added))
+			return nil
+		}
+		if len(added) == 0 {
+			core.KeyValue("merged", "every account is configured already")
+			return nil
+		}
+		if err := store.SaveSourceAccounts(discoverSourceFlag, merged); err != nil {
+			return err
+		}
+		for _, account := range added {
+			core.KeyValue("added", fmt.Sprintf("%s [%s]", account.Name, account.Number))
+		}
+		return nil
+	},
+}
+
+func init() {
+	accountsDiscoverCmd.Flags().IntVar(
+		&discoverSourceFlag,
+		"source",
+		0,
+		"index of the configured source to log in to, as listed by sources list",
+	)
+	accountsDiscoverCmd.Flags().BoolVar(
+		&discoverMergeFlag,
+		"merge",
+		false,
+		"add the accounts that aren't configured yet to the source in the config file",
+	)
+
+	accountsCmd.AddCommand(accountsDiscoverCmd)
+	rootCmd.AddCommand(accountsCmd)
+}
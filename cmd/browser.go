@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/spf13/cobra"
+)
+
+// GetAutomationOptions merges the browser section of the config with any
+// browser flags given on the command line. Flags win over the config.
+func GetAutomationOptions(cmd *cobra.Command) []core.AutomationOptionator {
+	config := store.GetConfig().Browser
+	flags := cmd.Flags()
+
+	headless := config.Headless
+	if flags.Changed("headless") {
+		headless = headlessFlag
+	}
+
+	execPath := config.ExecPath
+	if flags.Changed("chrome-path") {
+		execPath = chromePathArg
+	}
+
+	userDataDir := config.UserDataDir
+	if flags.Changed("user-data-dir") {
+		userDataDir = userDataDirArg
+	}
+
+	windowWidth := config.WindowWidth
+	if flags.Changed("window-width") {
+		windowWidth = windowWidthArg
+	}
+
+	windowHeight := config.WindowHeight
+	if flags.Changed("window-height") {
+		windowHeight = windowHeightArg
+	}
+
+	userAgent := config.UserAgent
+	if flags.Changed("user-agent") {
+		userAgent = userAgentArg
+	}
+
+	slowMotion := config.SlowMotion
+	if flags.Changed("slow-motion") {
+		slowMotion = slowMotionArg
+	}
+
+	options := []core.AutomationOptionator{
+		core.WithHeadless(headless),
+		core.WithExecPath(execPath),
+		core.WithUserDataDir(userDataDir),
+		core.WithWindowSize(windowWidth, windowHeight),
+		core.WithUserAgent(userAgent),
+		core.WithSlowMotion(time.Duration(slowMotion) * time.Millisecond),
+		core.WithRemoteURL(GetRemoteURL()),
+		core.WithTimeouts(GetTimeouts()),
+	}
+
+	for name, value := range config.Flags {
+		options = append(options, core.WithFlag(name, value))
+	}
+
+	// --chrome-flag name or --chrome-flag name=value
+	for _, flag := range chromeFlagsArg {
+		name, value, found := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+		if !found {
+			options = append(options, core.WithFlag(name, true))
+			continue
+		}
+		options = append(options, core.WithFlag(name, value))
+	}
+
+	return options
+}
+
+// GetRemoteURL returns the DevTools url of a browser to attach to, if any.
+func GetRemoteURL() string {
+	if remoteURLArg != "" {
+		return remoteURLArg
+	}
+	return store.GetConfig().Browser.RemoteURL
+}
+
+// GetTimeouts applies the timeouts in the config over the defaults.
+func GetTimeouts() core.AutomationTimeouts {
+	config := store.GetConfig().Timeouts
+	timeouts := core.DefaultAutomationTimeouts()
+
+	override := func(timeout *time.Duration, ms int) {
+		if ms > 0 {
+			*timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	override(&timeouts.Find, config.Find)
+	override(&timeouts.Click, config.Click)
+	override(&timeouts.Navigate, config.Navigate)
+	override(&timeouts.Download, config.Download)
+	override(&timeouts.Login, config.Login)
+	override(&timeouts.Account, config.Account)
+	override(&timeouts.Run, config.Run)
+
+	return timeouts
+}
+
+// GetRetries applies the retry policies in the config over the defaults.
+func GetRetries() (core.AutomationRetries, error) {
+	config := store.GetConfig().Retries
+	retries := core.DefaultAutomationRetries()
+
+	override := func(name string, policy *core.RetryPolicy, config *store.RetryPolicyConfig) error {
+		if config == nil {
+			return nil
+		}
+		retryOn, err := core.ParseErrorClasses(config.RetryOn)
+		if err != nil {
+			return fmt.Errorf("retries.%s.retryOn: %w", name, err)
+		}
+		*policy = core.RetryPolicy{
+			Attempts:   config.Attempts,
+			Backoff:    time.Duration(config.Backoff) * time.Millisecond,
+			Multiplier: config.Multiplier,
+			MaxBackoff: time.Duration(config.MaxBackoff) * time.Millisecond,
+			RetryOn:    retryOn,
+		}
+		return nil
+	}
+	if err := override("action", &retries.Action, config.Action); err != nil {
+		return retries, err
+	}
+	if err := override("account", &retries.Account, config.Account); err != nil {
+		return retries, err
+	}
+
+	return retries, nil
+}
+
+// GetBlockRules are the requests blocked while downloading a source, the
+// default trackers and ads along with those in its config.
+func GetBlockRules(config store.SourceConfig) (core.BlockRules, error) {
+	urls := []string{}
+	if config.Block.Defaults == nil || *config.Block.Defaults {
+		urls = append(urls, core.DefaultBlockedURLs...)
+	}
+	urls = append(urls, config.Block.URLs...)
+
+	rules, err := core.NewBlockRules(urls, config.Block.ResourceTypes)
+	if err != nil {
+		return rules, fmt.Errorf("block: %w", err)
+	}
+	return rules, nil
+}
+
+// GetEmulation is the timezone, locale and location the browser pretends to
+// be in while downloading the source.
+func GetEmulation(config store.SourceConfig) (core.Emulation, error) {
+	var geolocation *core.Geolocation
+	if config.Geolocation != nil {
+		geolocation = &core.Geolocation{
+			Latitude:  config.Geolocation.Latitude,
+			Longitude: config.Geolocation.Longitude,
+			Accuracy:  config.Geolocation.Accuracy,
+		}
+		if geolocation.Accuracy <= 0 {
+			geolocation.Accuracy = 100
+		}
+	}
+	return core.NewEmulation(config.Timezone, config.Locale, geolocation)
+}
+
+// GetNetworkOptions are the proxy and client certificates the source is
+// downloaded through, none when it has neither.
+func GetNetworkOptions(config store.SourceConfig) ([]core.AutomationOptionator, error) {
+	options := []core.AutomationOptionator{}
+
+	if config.Proxy != nil {
+		username, password := "", ""
+		if len(config.Proxy.Credentials) > 0 {
+			credentials, err := store.NewCredentials(config.Proxy.Credentials)
+			if err != nil {
+				return nil, fmt.Errorf("proxy.credentials: %w", err)
+			}
+			username = credentials.ResolvedUsername()
+			password = credentials.ResolvedPassword()
+		}
+		proxy, err := core.NewProxyOptions(config.Proxy.URL, username, password, config.Proxy.Bypass)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		options = append(options, core.WithProxy(proxy))
+	}
+
+	if len(config.ClientCertificates) > 0 {
+		certificates := []core.ClientCertificate{}
+		for _, certificate := range config.ClientCertificates {
+			certificates = append(certificates, core.ClientCertificate{
+				Hosts:    certificate.Hosts,
+				CertFile: certificate.Cert,
+				KeyFile:  certificate.Key,
+				CAFile:   certificate.CA,
+			})
+		}
+		options = append(options, core.WithClientCertificates(certificates))
+	}
+
+	return options, nil
+}
+
+// GetExceptionPolicy is what happens when a page of the source throws.
+func GetExceptionPolicy(config store.SourceConfig) (core.ExceptionPolicy, error) {
+	policy, err := core.NewExceptionPolicy(
+		config.Exceptions.Action,
+		config.Exceptions.Allow,
+		config.Exceptions.Deny,
+	)
+	if err != nil {
+		return policy, fmt.Errorf("exceptions: %w", err)
+	}
+	return policy, nil
+}
+
+// GetInputOptions applies the input section of the browser config over
+// the defaults.
+func GetInputOptions() (core.InputOptions, error) {
+	config := store.GetConfig().Browser.Input
+	options := core.DefaultInputOptions()
+
+	mode, err := core.ParseInputMode(config.Mode)
+	if err != nil {
+		return options, fmt.Errorf("browser.input.mode: %w", err)
+	}
+	options.Mode = mode
+	options.Keystrokes = config.Keystrokes
+	if config.KeyDelay > 0 {
+		options.KeyDelay = time.Duration(config.KeyDelay) * time.Millisecond
+	}
+	options.Jitter = time.Duration(config.Jitter) * time.Millisecond
+
+	return options, nil
+}
@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/airtonix/bank-downloaders/core"
 	"github.com/airtonix/bank-downloaders/processors"
@@ -10,85 +15,485 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var recordHarFlag bool
+var freshLoginFlag bool
+var parallelFlag int
+var screencastFlag = core.EnumFlag([]string{string(core.ScreencastFormatPNG), string(core.ScreencastFormatGIF)}, "")
+
+// DownloadRun holds everything shared by the sources downloaded in one run.
+type DownloadRun struct {
+	History   *store.History
+	Strategy  store.HistoryStrategy
+	Report    *core.RunReport
+	Artifacts *core.RunArtifacts
+	// shared by every source when they are downloaded one at a time
+	Automation *core.Automation
+	// used to create an isolated automation per source otherwise
+	Options []core.AutomationOptionator
+	// bounds the whole run, however many automations it creates
+	Context context.Context
+	// how many sources are downloaded side by side
+	Parallel  int
+	RecordHar bool
+	// how each source's screencast is saved, none is recorded when empty
+	Screencast core.ScreencastFormat
+	Sessions   *store.SessionStore
+	// the export formats read from each bank's site
+	Formats *store.FormatCache
+	// log in even when a saved session could be resumed
+	FreshLogin bool
+}
+
 var downloadCmd = &cobra.Command{
 	Use:   "download",
 	Short: "dwnloads transactions from a source",
-	Run: func(cmd *cobra.Command, args []string) {
-		history := store.GetHistory()
+	// failures are reported in the run summary, not as a usage problem
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		config := store.GetConfig()
+		artifacts := core.NewRunArtifacts(config.ArtifactsDir)
 
-		automation := core.NewAutomation()
-		strategy := store.NewHistoryStrategy(cmd.Flag("range-strategy").Value.String())
-		core.KeyValue("strategy", strategy.ToString())
+		retries, err := GetRetries()
+		if err != nil {
+			return err
+		}
+		input, err := GetInputOptions()
+		if err != nil {
+			return err
+		}
 
-		core.Header("Downloading Transactions")
+		trace := core.NewStepTrace(filepath.Join(artifacts.Dir, core.TraceFile))
+		defer trace.Close()
 
-		for _, item := range config.Sources {
-			credentials := store.NewCredentials(
-				item.Config.Credentials,
-			)
-
-			source, err := processors.GetProcecssorFactory(
-				item.Type,
-				item.Config,
-				credentials,
-				automation,
-			)
+		// one budget for the whole run, not one per source
+		runCtx, cancelRun := core.NewRunContext(GetTimeouts().Run)
+		defer cancelRun()
+
+		options := append(
+			GetAutomationOptions(cmd),
+			core.WithRunContext(runCtx),
+			core.WithArtifacts(artifacts),
+			core.WithTrace(trace),
+			core.WithRetries(retries),
+			core.WithInput(input),
+		)
+
+		var automation *core.Automation
+		if parallelFlag <= 1 {
+			automation, err = core.NewAutomation(options...)
 			if err != nil {
-				continue
+				return err
 			}
+		}
 
-			core.KeyValue("source", item.Type)
-			core.KeyValue("accounts", len(item.Accounts))
+		sessions, err := store.NewSessionStore(config.SessionsDir)
+		if core.AssertErrorToNilf("sessions will not be persisted: %w", err) {
+			sessions = nil
+		}
+		formats, err := store.NewFormatCache("")
+		if core.AssertErrorToNilf("export formats will not be cached: %w", err) {
+			formats = nil
+		}
 
-			core.Action("\nlogging in...")
-			err = source.Login()
-			if core.AssertErrorToNilf("could not login: %w", err) {
-				continue
+		run := &DownloadRun{
+			History:    store.GetHistory(),
+			Strategy:   store.NewHistoryStrategy(cmd.Flag("range-strategy").Value.String()),
+			Report:     core.NewRunReport(),
+			Artifacts:  artifacts,
+			Automation: automation,
+			Options:    options,
+			Context:    runCtx,
+			Parallel:   parallelFlag,
+			RecordHar:  recordHarFlag,
+			Screencast: core.ScreencastFormat(screencastFlag.Value),
+			Sessions:   sessions,
+			Formats:    formats,
+			FreshLogin: freshLoginFlag,
+		}
+		core.KeyValue("strategy", run.Strategy.ToString())
+
+		core.Header("Downloading Transactions")
+
+		run.DownloadSources(config.Sources)
+
+		run.Report.Print()
+		if run.Report.HasFailures() {
+			return fmt.Errorf("%d accounts failed to download", len(run.Report.Failures))
+		}
+
+		return nil
+	},
+}
+
+// DownloadSources downloads every source, up to Parallel of them at a time.
+func (run *DownloadRun) DownloadSources(sources []store.Source) {
+	workers := run.Parallel
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				run.DownloadSource(index, sources[index])
 			}
+		}()
+	}
+
+	for index := range sources {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+}
 
-			for _, account := range item.Accounts {
-				logrus.Infof("\nprocessing account: %s [%s]\n", account.Name, account.Number)
-				daysToFetch := item.Config.DaysToFetch
+// the automation a source runs in, the shared one when sources run one at
+// a time, otherwise a new one in its own incognito context. Sources with a
+// proxy or client certificates always get their own.
+func (run *DownloadRun) automationFor(label string, item store.Source) (*core.Automation, func(), error) {
+	automation := run.Automation
+	closeAutomation := func() {}
 
-				fromDate, toDate, err := history.GetDownloadDateRange(
-					item.Type,
-					account.Number,
-					daysToFetch,
-					strategy,
-				)
-				if err != nil {
-					logrus.Warnf("Skipping: %s. Since %s", account.Number, err)
-					continue
-				}
-				core.KeyValue("date range",
-					fmt.Sprintf("%d: %v - %v", daysToFetch, fromDate, toDate),
-				)
-				filename, err := source.DownloadTransactions(
-					account.Name,
-					account.Number,
-					fromDate,
-					toDate,
-				)
+	network, err := GetNetworkOptions(item.Config)
+	if err != nil {
+		return nil, nil, err
+	}
 
-				if core.AssertErrorToNilf("could not download transactions: %w", err) {
-					continue
-				}
+	if run.Parallel > 1 || len(network) > 0 {
+		options := append([]core.AutomationOptionator{}, run.Options...)
+		options = append(options, network...)
+		automation, err = core.NewAutomation(
+			append(options, core.WithIsolatedContext())...,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeAutomation = automation.CloseBrowser
+	}
 
-				logrus.Infoln(
-					fmt.Sprintf(
-						"Downloaded transactions for %s from %s to %s as %s",
-						account.Name, fromDate, toDate, filename,
-					),
-				)
-				history.SaveEvent(
-					item.Type,
-					account.Number,
-					toDate,
+	// every line carries the source, so interleaved output stays readable
+	automation.Log = logrus.WithField("source", label)
+	automation.TraceContext = core.TraceContext{Source: label}
+	return automation, closeAutomation, nil
+}
+
+// DownloadSource logs into a source and downloads each of its accounts,
+// recording the outcome of every account in the run report.
+func (run *DownloadRun) DownloadSource(index int, item store.Source) {
+	sourceName := string(item.Type)
+	label := fmt.Sprintf("%02d-%s", index, sourceName)
+	log := logrus.WithField("source", label)
+
+	// a source that can't start fails all of its accounts
+	failSource := func(err error) {
+		log.Errorf("Skipping source: %s. Since %s", sourceName, err)
+		for _, account := range item.Accounts {
+			run.Report.AddFailure(sourceName, account.Name, err)
+		}
+	}
+
+	// the run ran out of time before this source's turn came
+	if run.Context != nil && run.Context.Err() != nil {
+		failSource(fmt.Errorf("%w: the run ended before %s started", core.ErrDeadlineExceeded, label))
+		return
+	}
+
+	// caught before a browser is spent on it
+	if err := ValidateSource(item, run.Formats); err != nil {
+		failSource(err)
+		return
+	}
+
+	automation, closeAutomation, err := run.automationFor(label, item)
+	if err != nil {
+		failSource(err)
+		return
+	}
+	defer closeAutomation()
+	automation.TraceContext.Processor = sourceName
+
+	credentials, err := store.NewCredentials(
+		item.Config.Credentials,
+	)
+	if err != nil {
+		failSource(err)
+		return
+	}
+
+	if run.RecordHar {
+		har := automation.RecordHar(credentials.ResolvedCredentials.Secrets()...)
+		defer run.SaveHar(automation, label, har)
+	}
+
+	if run.Screencast != "" {
+		dir, err := run.Artifacts.Path("screencast", core.Slugify(label))
+		var screencast *core.ScreencastRecorder
+		if err == nil {
+			screencast, err = automation.RecordScreencast(dir)
+		}
+		if !core.AssertErrorToNilf("could not record screencast: %w", err) {
+			defer run.SaveScreencast(automation, label, screencast)
+		}
+	}
+
+	emulation, err := GetEmulation(item.Config)
+	if err != nil {
+		failSource(err)
+		return
+	}
+	if err := automation.Emulate(emulation); err != nil {
+		failSource(err)
+		return
+	}
+
+	policy, err := GetExceptionPolicy(item.Config)
+	if err != nil {
+		failSource(err)
+		return
+	}
+	exceptions := automation.WatchExceptions(policy)
+	defer func() {
+		exceptions.Stop()
+		for _, exception := range exceptions.Reported() {
+			run.Report.AddException(sourceName, exception)
+		}
+	}()
+
+	rules, err := GetBlockRules(item.Config)
+	if err != nil {
+		failSource(err)
+		return
+	}
+	blocker, err := automation.BlockRequests(rules)
+	if err != nil {
+		failSource(err)
+		return
+	}
+	defer func() {
+		core.AssertErrorToNilf("could not stop blocking requests: %w", blocker.Stop())
+		log.Debugf("blocked %d requests", blocker.Blocked())
+	}()
+
+	source, err := processors.GetProcecssorFactory(
+		item.Type,
+		item.Config,
+		credentials,
+		automation,
+	)
+	if err != nil {
+		failSource(err)
+		return
+	}
+
+	log.WithField("accounts", len(item.Accounts)).Info("logging in...")
+	err = run.Login(automation, item, credentials, source)
+	if err != nil {
+		failSource(err)
+		return
+	}
+
+	if err := run.CheckFormat(automation, item, source); err != nil {
+		failSource(err)
+		return
+	}
+
+	for _, account := range item.Accounts {
+		log.Infof("processing account: %s [%s]", account.Name, account.Number)
+		automation.TraceContext.Account = account.Name
+		daysToFetch := item.Config.DaysToFetch
+
+		fromDate, toDate, err := run.History.GetDownloadDateRange(
+			item.Type,
+			account.Number,
+			daysToFetch,
+			run.Strategy,
+			emulation.Location(),
+		)
+		if err != nil {
+			log.Warnf("Skipping: %s. Since %s", account.Number, err)
+			continue
+		}
+		log.Infof("date range %d: %v - %v", daysToFetch, fromDate, toDate)
+		var filename string
+		step := fmt.Sprintf("account %s", account.Name)
+		// each attempt gets the whole account timeout to itself
+		err = automation.Options.Retries.Account.Run(
+			automation.Context,
+			automation.Log,
+			step,
+			func() error {
+				return automation.Scope(
+					step,
+					automation.Options.Timeouts.Account,
+					func() error {
+						filename, err = source.DownloadTransactions(
+							account.Name,
+							account.Number,
+							fromDate,
+							toDate,
+						)
+						return err
+					},
 				)
+			},
+		)
+
+		if core.AssertErrorToNilf("could not download transactions: %w", err) {
+			run.Report.AddFailure(sourceName, account.Name, err)
+			continue
+		}
+
+		log.Infof(
+			"Downloaded transactions for %s from %s to %s as %s",
+			account.Name, fromDate, toDate, filename,
+		)
+		run.History.SaveEvent(
+			item.Type,
+			account.Number,
+			toDate,
+		)
+		run.Report.AddSuccess(sourceName, account.Name)
+	}
+}
+
+// Login resumes the saved session of a source while it is still valid,
+// otherwise it logs in, saving the new session when the source persists it.
+func (run *DownloadRun) Login(automation *core.Automation, item store.Source, credentials store.Credentials, source processors.IProcessor) error {
+	sourceName := string(item.Type)
+
+	resumable, canResume := source.(processors.ISessionProcessor)
+	if item.Config.PersistSession && !canResume {
+		automation.Log.Warnf("%s can't resume a session, logging in every time", sourceName)
+	}
+	persist := item.Config.PersistSession && canResume && run.Sessions != nil
+	name := store.SessionName(item.Type, item.Config.Domain, credentials.ResolvedUsername())
+	secret := credentials.SessionSecret()
+
+	if persist && !run.FreshLogin {
+		err := run.ResumeSession(automation, name, secret, sourceName, resumable)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, os.ErrNotExist):
+			automation.Log.Debugf("no saved session for %s", sourceName)
+		default:
+			automation.Log.Infof("Logging into %s again, since %s", sourceName, err)
+			core.AssertErrorToNilf("could not forget session: %w", run.Sessions.Forget(name))
+		}
+	}
+
+	err := automation.Scope(
+		fmt.Sprintf("login to %s", sourceName),
+		automation.Options.Timeouts.Login,
+		source.Login,
+	)
+	if err != nil {
+		return err
+	}
+
+	if persist {
+		state, err := automation.CaptureSession()
+		if err == nil {
+			err = run.Sessions.Save(name, secret, state)
+		}
+		if !core.AssertErrorToNilf("could not save session: %w", err) {
+			automation.Log.Infof("Saved session for %s", sourceName)
+		}
+	}
+
+	return nil
+}
+
+// CheckFormat reads the export formats a source's site offers, unless they
+// were read recently, and checks the source's format is one of them. Sites
+// whose formats can't be read are left for the download to find out.
+func (run *DownloadRun) CheckFormat(automation *core.Automation, item store.Source, source processors.IProcessor) error {
+	lister, ok := source.(processors.IFormatProcessor)
+	if !ok || run.Formats == nil || item.Config.ExportFormat == "" {
+		return nil
+	}
+	// checked before logging in
+	if _, cached := run.Formats.Get(item.Type, item.Config.Domain); cached {
+		return nil
+	}
+
+	var formats []string
+	err := automation.Scope(
+		fmt.Sprintf("read export formats of %s", item.Type),
+		automation.Options.Timeouts.Account,
+		func() (err error) {
+			formats, err = lister.ListFormats()
+			return err
+		},
+	)
+	if err != nil {
+		automation.Log.Warnf("could not read export formats: %s", err)
+		return nil
+	}
+	core.AssertErrorToNilf("could not cache export formats: %w", run.Formats.Save(item.Type, item.Config.Domain, formats))
+
+	return processors.ValidateExportFormat(item, formats)
+}
+
+// ResumeSession restores the saved session of a source and checks the
+// bank still considers it logged in.
+func (run *DownloadRun) ResumeSession(automation *core.Automation, name string, secret []byte, sourceName string, source processors.ISessionProcessor) error {
+	state, err := run.Sessions.Load(name, secret)
+	if err != nil {
+		return err
+	}
+
+	return automation.Scope(
+		fmt.Sprintf("resume session of %s", sourceName),
+		automation.Options.Timeouts.Login,
+		func() error {
+			if err := automation.RestoreSession(state); err != nil {
+				return err
 			}
+			return source.ResumeSession()
+		},
+	)
+}
+
+// SaveHar stops the recording and writes it into the run artifacts.
+func (run *DownloadRun) SaveHar(automation *core.Automation, label string, har *core.HarRecorder) {
+	har.Stop()
+
+	path, err := run.Artifacts.Path("har", core.Slugify(label)+".har")
+	if err == nil {
+		err = har.Save(path)
+	}
+	if core.AssertErrorToNilf("could not save har: %w", err) {
+		return
+	}
+	automation.Log.WithField("har", path).Infof("Recorded %s", label)
+}
+
+// SaveScreencast stops the recording, turning the frames into a gif when
+// asked to.
+func (run *DownloadRun) SaveScreencast(automation *core.Automation, label string, screencast *core.ScreencastRecorder) {
+	index, err := screencast.Stop()
+	if core.AssertErrorToNilf("could not save screencast: %w", err) {
+		return
+	}
+
+	path := screencast.Dir
+	if run.Screencast == core.ScreencastFormatGIF {
+		path, err = run.Artifacts.Path("screencast", core.Slugify(label)+".gif")
+		if err == nil {
+			err = core.SaveScreencastGIF(screencast.Dir, index, path)
 		}
-	},
+		if core.AssertErrorToNilf("could not save screencast: %w", err) {
+			return
+		}
+		// the frames are in the gif now
+		os.RemoveAll(screencast.Dir)
+	}
+	automation.Log.WithField("screencast", path).Infof("Recorded %d frames of %s", len(index.Frames), label)
 }
 
 func init() {
@@ -100,6 +505,34 @@ func init() {
 		"r",
 		"strategy to use when determining the date range to download: days-ago, since-last-download",
 	)
+	downloadCmd.Flags().BoolVar(
+		&recordHarFlag,
+		"record-har",
+		false,
+		"record the network traffic of each source as a HAR file in the run artifacts, with credentials and cookies redacted",
+	)
+
+	downloadCmd.Flags().Var(
+		screencastFlag,
+		"record-screencast",
+		"record what the browser shows for each source into the run artifacts, as a gif or as png frames with a timing index: gif, png",
+	)
+	// a bare --record-screencast records a gif
+	downloadCmd.Flag("record-screencast").NoOptDefVal = string(core.ScreencastFormatGIF)
+
+	downloadCmd.Flags().BoolVar(
+		&freshLoginFlag,
+		"fresh-login",
+		false,
+		"log in even when a persisted session could be resumed, replacing the saved session",
+	)
+
+	downloadCmd.Flags().IntVar(
+		&parallelFlag,
+		"parallel",
+		1,
+		"number of sources to download at the same time, each in its own incognito browser context",
+	)
 
 	rootCmd.AddCommand(downloadCmd)
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/processors"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/spf13/cobra"
+)
+
+var formatsSourceFlag int
+
+var formatsCmd = &cobra.Command{
+	Use:   "formats",
+	Short: "inspect the export formats of a configured source",
+}
+
+var formatsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "log in to a source and list the export formats its site offers",
+	// a misconfigured format is listed, not a usage problem
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// no point logging in to a bank that can't list them
+		sources := store.GetConfig().Sources
+		if formatsSourceFlag >= 0 && formatsSourceFlag < len(sources) {
+			registration, err := processors.Lookup(sources[formatsSourceFlag].Type)
+			if err == nil && !registration.HasCapability(processors.CapabilityListFormats) {
+				return fmt.Errorf("%w: %s can not list export formats, it supports %s", core.ErrUnsupportedSource, registration.Type, joinNames(registration.ExportFormats))
+			}
+		}
+
+		source, done, err := OpenSource(cmd, formatsSourceFlag)
+		if err != nil {
+			return err
+		}
+		defer done()
+		item := sources[formatsSourceFlag]
+
+		lister, ok := source.(processors.IFormatProcessor)
+		if !ok {
+			return fmt.Errorf("%w: %s can not list export formats", core.ErrUnsupportedSource, item.Type)
+		}
+		formats, err := lister.ListFormats()
+		if err != nil {
+			return err
+		}
+
+		cache, err := store.NewFormatCache("")
+		if err == nil {
+			err = cache.Save(item.Type, item.Config.Domain, formats)
+		}
+		core.AssertErrorToNilf("could not cache export formats: %w", err)
+
+		core.Header(fmt.Sprintf("Export formats of %02d-%s", formatsSourceFlag, item.Type))
+		for _, format := range formats {
+			if format == item.Config.ExportFormat {
+				core.KeyValue(format, "configured")
+				continue
+			}
+			core.KeyValue(format, "")
+		}
+		return processors.ValidateExportFormat(item, formats)
+	},
+}
+
+func init() {
+	formatsListCmd.Flags().IntVar(
+		&formatsSourceFlag,
+		"source",
+		0,
+		"index of the configured source to log in to, as listed by sources list",
+	)
+
+	formatsCmd.AddCommand(formatsListCmd)
+	rootCmd.AddCommand(formatsCmd)
+}
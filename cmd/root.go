@@ -9,6 +9,7 @@ import (
 
 	"github.com/airtonix/bank-downloaders/core"
 	"github.com/airtonix/bank-downloaders/meta"
+	"github.com/airtonix/bank-downloaders/processors"
 	"github.com/airtonix/bank-downloaders/store"
 	"github.com/sirupsen/logrus"
 	"github.com/snowzach/rotatefilehook"
@@ -19,6 +20,14 @@ var configFileArg string
 var historyFileArg string
 var debugFlag bool
 var headlessFlag bool
+var chromePathArg string
+var userDataDirArg string
+var windowWidthArg int
+var windowHeightArg int
+var userAgentArg string
+var chromeFlagsArg []string
+var slowMotionArg int
+var remoteURLArg string
 
 var envvarPrefix string = strings.ToUpper(meta.Name)
 var debugEnvVarName string = fmt.Sprintf("%s_DEBUG", envvarPrefix)
@@ -27,6 +36,11 @@ var disableLogEnvVarName string = fmt.Sprintf("%s_DISABLE_LOG_FILE", envvarPrefi
 var rootCmd = &cobra.Command{
 	Use:   meta.Name,
 	Short: meta.Description,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// a missing browser or broken config is not a usage problem
+		cmd.SilenceUsage = true
+		return Initialize()
+	},
 }
 
 func init() {
@@ -34,7 +48,14 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&historyFileArg, "history", "", "history file")
 	rootCmd.PersistentFlags().BoolVar(&debugFlag, "debug", false, "shwo debug messages")
 	rootCmd.PersistentFlags().BoolVar(&headlessFlag, "headless", true, "run browser in headless mode?")
-	cobra.OnInitialize(Initialize)
+	rootCmd.PersistentFlags().StringVar(&chromePathArg, "chrome-path", "", "path to the chrome executable")
+	rootCmd.PersistentFlags().StringVar(&userDataDirArg, "user-data-dir", "", "directory to use for the chrome profile")
+	rootCmd.PersistentFlags().IntVar(&windowWidthArg, "window-width", 0, "initial width of the browser window")
+	rootCmd.PersistentFlags().IntVar(&windowHeightArg, "window-height", 0, "initial height of the browser window")
+	rootCmd.PersistentFlags().StringVar(&userAgentArg, "user-agent", "", "user agent reported by the browser")
+	rootCmd.PersistentFlags().StringArrayVar(&chromeFlagsArg, "chrome-flag", []string{}, "extra chrome switch as name or name=value, can be repeated")
+	rootCmd.PersistentFlags().IntVar(&slowMotionArg, "slow-motion", 100, "milliseconds to wait before each browser action")
+	rootCmd.PersistentFlags().StringVar(&remoteURLArg, "remote-url", "", "DevTools url of a running browser to connect to instead of launching chrome")
 }
 
 func Execute() {
@@ -44,13 +65,34 @@ func Execute() {
 	}
 }
 
-func Initialize() {
+// Initialize loads the config, history and processors, and checks a
+// browser is available, before any command runs.
+func Initialize() error {
 	InitLogger(nil)
-	core.EnsureChromeExists()
 
 	store.InitialiseSchemas()
 	store.InitConfig(configFileArg)
 	store.InitHistory(configFileArg)
+
+	// banks defined in yaml, next to the ones built in
+	loaded, err := processors.LoadDeclarativeProcessors(store.GetProcessorsDir())
+	core.AssertErrorToNilf("could not load every processor: %w", err)
+	if len(loaded) > 0 {
+		logrus.Debugf("loaded processors: %v", loaded)
+	}
+	if err := processors.ValidateSourcesSelectors(store.GetConfig().Sources); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// a remote browser brings its own chrome
+	if GetRemoteURL() != "" {
+		return nil
+	}
+
+	return core.EnsureChromeExists(
+		chromePathArg,
+		store.GetConfig().Browser.ExecPath,
+	)
 }
 
 func InitLogger(hook logrus.Hook) {
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/processors"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// ValidateSource checks the source against its processor. Its format is
+// checked against the export formats last read from its bank's site when
+// formats has them, otherwise against those its processor registered.
+func ValidateSource(item store.Source, formats *store.FormatCache) error {
+	if formats != nil {
+		if live, ok := formats.Get(item.Type, item.Config.Domain); ok {
+			if err := processors.ValidateSourceLogin(item); err != nil {
+				return err
+			}
+			return processors.ValidateExportFormat(item, live)
+		}
+	}
+	return processors.ValidateSource(item)
+}
+
+// OpenSource starts a browser for the configured source at index and logs
+// into it, resuming its saved session when it persists one. done closes the
+// browser once the caller is finished with it.
+func OpenSource(cmd *cobra.Command, index int) (source processors.IProcessor, done func(), err error) {
+	config := store.GetConfig()
+	if index < 0 || index >= len(config.Sources) {
+		return nil, nil, fmt.Errorf("no source %d, there are %d configured", index, len(config.Sources))
+	}
+	item := config.Sources[index]
+	label := fmt.Sprintf("%02d-%s", index, item.Type)
+
+	// the format is of no concern to logging in, and formats list is how
+	// a wrong one is found out
+	if err := processors.ValidateSourceLogin(item); err != nil {
+		return nil, nil, err
+	}
+
+	retries, err := GetRetries()
+	if err != nil {
+		return nil, nil, err
+	}
+	input, err := GetInputOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	network, err := GetNetworkOptions(item.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := append(
+		GetAutomationOptions(cmd),
+		core.WithArtifacts(core.NewRunArtifacts(config.ArtifactsDir)),
+		core.WithRetries(retries),
+		core.WithInput(input),
+	)
+	options = append(options, network...)
+	if len(network) > 0 {
+		options = append(options, core.WithIsolatedContext())
+	}
+	automation, err := core.NewAutomation(options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeAutomation := func() {}
+	if len(network) > 0 {
+		closeAutomation = automation.CloseBrowser
+	}
+	automation.Log = logrus.WithField("source", label)
+	automation.TraceContext = core.TraceContext{Source: label, Processor: string(item.Type)}
+
+	// the browser is of no use to the caller when logging in fails
+	defer func() {
+		if err != nil {
+			closeAutomation()
+		}
+	}()
+
+	credentials, err := store.NewCredentials(item.Config.Credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+	emulation, err := GetEmulation(item.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := automation.Emulate(emulation); err != nil {
+		return nil, nil, err
+	}
+
+	source, err = processors.GetProcecssorFactory(item.Type, item.Config, credentials, automation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessions, err := store.NewSessionStore(config.SessionsDir)
+	if core.AssertErrorToNilf("sessions will not be persisted: %w", err) {
+		sessions = nil
+	}
+	run := &DownloadRun{Sessions: sessions}
+	if err := run.Login(automation, item, credentials, source); err != nil {
+		return nil, nil, err
+	}
+	return source, closeAutomation, nil
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/processors"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/spf13/cobra"
+)
+
+var sourcesCmd = &cobra.Command{
+	Use:   "sources",
+	Short: "inspect the banks transactions can be downloaded from",
+}
+
+var sourcesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the supported banks, and check the configured sources against them",
+	// misconfigured sources are listed, not a usage problem
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, registration := range processors.Registered() {
+			core.Header(string(registration.Type))
+			core.KeyValue("description", registration.Description)
+			core.KeyValue("credentials", joinNames(registration.Credentials))
+			core.KeyValue("export formats", strings.Join(registration.ExportFormats, ", "))
+			core.KeyValue("capabilities", joinNames(registration.Capabilities))
+		}
+
+		sources := store.GetConfig().Sources
+		if len(sources) == 0 {
+			return nil
+		}
+
+		formats, err := store.NewFormatCache("")
+		if core.AssertErrorToNilf("cached export formats are not checked: %w", err) {
+			formats = nil
+		}
+
+		core.Header("Configured Sources")
+		invalid := 0
+		for index, source := range sources {
+			label := fmt.Sprintf("%02d-%s", index, source.Type)
+			if err := ValidateSource(source, formats); err != nil {
+				invalid++
+				core.KeyValue(label, err.Error())
+				continue
+			}
+			core.KeyValue(label, fmt.Sprintf("ok, %d accounts", len(source.Accounts)))
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d sources are misconfigured", invalid)
+		}
+		return nil
+	},
+}
+
+var selectorsSourceFlag int
+
+var sourcesSelectorsCmd = &cobra.Command{
+	Use:   "selectors <type>",
+	Short: "print the selectors a bank's processor uses, with a configured source's overrides applied",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		registration, err := processors.Lookup(store.SourceType(args[0]))
+		if err != nil {
+			return err
+		}
+
+		// the first configured source of the type, unless one is picked
+		sources := store.GetConfig().Sources
+		overrides := map[string]string{}
+		label := "defaults"
+		if cmd.Flags().Changed("source") {
+			if selectorsSourceFlag < 0 || selectorsSourceFlag >= len(sources) {
+				return fmt.Errorf("no source %d, there are %d configured", selectorsSourceFlag, len(sources))
+			}
+			if sources[selectorsSourceFlag].Type != registration.Type {
+				return fmt.Errorf("source %d is %s, not %s", selectorsSourceFlag, sources[selectorsSourceFlag].Type, registration.Type)
+			}
+			overrides = sources[selectorsSourceFlag].Config.Selectors
+			label = fmt.Sprintf("%02d-%s", selectorsSourceFlag, registration.Type)
+		} else {
+			for index, source := range sources {
+				if source.Type == registration.Type {
+					overrides = source.Config.Selectors
+					label = fmt.Sprintf("%02d-%s", index, registration.Type)
+					break
+				}
+			}
+		}
+
+		selectors, err := processors.OverrideSelectors(registration.Selectors, overrides)
+		core.Header(fmt.Sprintf("%s selectors (%s)", registration.Type, label))
+		for _, name := range core.SortedKeys(selectors) {
+			value := selectors[name]
+			if selectors[name] != registration.Selectors[name] {
+				value += " (overridden)"
+			}
+			core.KeyValue(name, value)
+		}
+		return err
+	},
+}
+
+func joinNames[T ~string](names []T) string {
+	output := []string{}
+	for _, name := range names {
+		output = append(output, string(name))
+	}
+	return strings.Join(output, ", ")
+}
+
+func init() {
+	sourcesSelectorsCmd.Flags().IntVar(
+		&selectorsSourceFlag,
+		"source",
+		0,
+		"index of the configured source whose overrides apply, defaults to the first of the type",
+	)
+
+	sourcesCmd.AddCommand(sourcesListCmd)
+	sourcesCmd.AddCommand(sourcesSelectorsCmd)
+	rootCmd.AddCommand(sourcesCmd)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/spf13/cobra"
+)
+
+var traceCmd = &cobra.Command{
+	Use:   "trace",
+	Short: "inspect the steps recorded during runs",
+}
+
+var traceShowCmd = &cobra.Command{
+	Use:   "show [run...]",
+	Short: "show the steps of runs as a timeline, the latest run when none is given",
+	Long: `Shows the steps of each run as a timeline, followed by a summary of every
+step across the runs, so that slow or flaky steps stand out.
+
+A run is the name of a directory in the artifacts directory, eg: 20231118-093000,
+or the path of a run directory or trace file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		artifactsDir := store.GetConfig().ArtifactsDir
+		if artifactsDir == "" {
+			artifactsDir = core.GetArtifactsDir()
+		}
+
+		runs := args
+		if len(runs) == 0 {
+			latest, err := LatestTracedRun(artifactsDir)
+			if err != nil {
+				return err
+			}
+			runs = []string{latest}
+		}
+
+		all := []core.TraceStep{}
+		for _, run := range runs {
+			path := TracePath(artifactsDir, run)
+			steps, err := core.ReadTrace(path)
+			if err != nil {
+				return fmt.Errorf("could not read trace of %s: %w", run, err)
+			}
+			all = append(all, steps...)
+
+			core.Header(fmt.Sprintf("Run %s", run))
+			core.RenderTrace(os.Stdout, steps)
+		}
+
+		core.Header("Steps")
+		core.RenderTraceSummary(os.Stdout, core.SummariseTrace(all))
+		return nil
+	},
+}
+
+// TracePath finds the trace of run, which is a trace file, a run directory
+// or the name of a run in artifactsDir.
+func TracePath(artifactsDir string, run string) string {
+	if info, err := os.Stat(run); err == nil {
+		if info.IsDir() {
+			return filepath.Join(run, core.TraceFile)
+		}
+		return run
+	}
+	return filepath.Join(artifactsDir, run, core.TraceFile)
+}
+
+// LatestTracedRun is the name of the newest run in artifactsDir that has a
+// trace, run names sort by when they started.
+func LatestTracedRun(artifactsDir string) (string, error) {
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return "", fmt.Errorf("could not find runs: %w", err)
+	}
+
+	runs := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(artifactsDir, entry.Name(), core.TraceFile)); err == nil {
+			runs = append(runs, entry.Name())
+		}
+	}
+	if len(runs) == 0 {
+		return "", fmt.Errorf("no traced runs in %s", artifactsDir)
+	}
+
+	sort.Strings(runs)
+	return runs[len(runs)-1], nil
+}
+
+func init() {
+	traceCmd.AddCommand(traceShowCmd)
+	rootCmd.AddCommand(traceCmd)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RunArtifacts is the directory that holds everything recorded during a
+// single run, eg: failure screenshots. Nothing is written until needed.
+type RunArtifacts struct {
+	RunID string
+	Dir   string
+
+	mu      sync.Mutex
+	counter int
+}
+
+// NewRunArtifacts names a new run inside baseDir, which defaults to the
+// artifacts directory resolved like the downloads directory.
+func NewRunArtifacts(baseDir string) *RunArtifacts {
+	if baseDir == "" {
+		baseDir = GetArtifactsDir()
+	}
+	runID := time.Now().Format("20060102-150405")
+
+	return &RunArtifacts{
+		RunID: runID,
+		Dir:   filepath.Join(baseDir, runID),
+	}
+}
+
+// GetArtifactsDir is where each run stores its artifacts directory.
+func GetArtifactsDir() string {
+	return ResolveFileArg(
+		"",
+		"BANKDOWNLOADER_ARTIFACTSDIR",
+		"artifacts",
+	)
+}
+
+// Path joins parts onto the run directory, creating the parent directories.
+func (r *RunArtifacts) Path(parts ...string) (string, error) {
+	output := filepath.Join(append([]string{r.Dir}, parts...)...)
+	if err := os.MkdirAll(filepath.Dir(output), 0750); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// WriteFile writes content to a file inside the run directory.
+func (r *RunArtifacts) WriteFile(content []byte, parts ...string) (string, error) {
+	output, err := r.Path(parts...)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(output, content, 0640); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// Next numbers things written during the run, eg: failure bundles, so
+// that automations running side by side never pick the same name.
+func (r *RunArtifacts) Next() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counter++
+	return r.counter
+}
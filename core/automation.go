@@ -3,16 +3,15 @@ package core
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/url"
-	"os"
 	"os/exec"
-	"path"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/chromedp/cdproto/browser"
-	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/security"
 	"github.com/chromedp/chromedp"
 	"github.com/sirupsen/logrus"
 )
@@ -20,69 +19,216 @@ import (
 type Automation struct {
 	Context context.Context
 	Cleanup context.CancelFunc
+	Options AutomationOptions
+	// the deadline currently bounding Context
+	scope *deadlineScope
+	// the browser tab, used when the run context can no longer be
+	browserContext context.Context
+	forensics      *Forensics
+	// selectors processors asked to type into
+	keystrokeSelectors map[string]bool
+	// where everything the automation does is logged, give it fields to
+	// tell automations running side by side apart
+	Log *logrus.Entry
+	// what the automation is working on, written with each traced step
+	TraceContext TraceContext
+	// applies the exception policy of the source being downloaded
+	exceptions *ExceptionMonitor
+	// how many times the browser was asked to run actions, so that traced
+	// steps can tell when they were retried
+	attempts int
+	// the login the browser answers the local proxy with, see answerProxy
+	proxyLogin *fetch.AuthChallengeResponse
+	// decides on paused requests while one is blocking them
+	blockerMu sync.Mutex
+	blocker   *RequestBlocker
 }
 
-type AutomationOptionator func(*context.Context) context.Context
+// IAutomation is what processors drive a browser with, so that they can be
+// given something other than chrome, like a FakeAutomation in tests.
+type IAutomation interface {
+	// where the processor's progress is logged
+	Logger() *logrus.Entry
+	GetLocation() url.URL
+	SetViewportSize(width int64, height int64) error
+	Goto(url string) error
+	Find(selector string) error
+	// waits for any of selectors, returning the one that was found
+	FindFirst(selectors ...string) (string, error)
+	Click(selector string) error
+	Focus(selector string) error
+	Fill(selector string, value string) error
+	// like Fill, without the value appearing in logs or traces
+	FillSensitive(selector string, value string) error
+	Pause(ms int) error
+	// reads fields, found by selectors relative to each element selector
+	// matches, of every such element. An empty field selector reads the
+	// element itself
+	ReadAll(selector string, fields map[string]string) ([]map[string]string, error)
+	// runs action and saves the file it downloads to downloadpath
+	DownloadFile(downloadpath string, action func() error, mimeTypes ...string) (string, error)
+	Download(request DownloadRequest, action func() error) ([]string, error)
+	CaptureResponses(urlPattern string) (*ResponseCapture, error)
+	// fills selectors key by key, see InputModeKeystrokes
+	UseKeystrokes(selectors ...string)
+}
+
+// ensure that Automation implements the IAutomation interface
+var _ IAutomation = (*Automation)(nil)
 
 var (
 	allocCtx context.Context
 )
 
 var allocateOnce sync.Once
+var allocErr error
 
 func NewAutomation(
 	options ...AutomationOptionator,
-) *Automation {
+) (*Automation, error) {
+	automationOptions := NewAutomationOptions(options...)
+
 	// Start the browser exactly once, as needed.
 	allocateOnce.Do(func() {
-		ctx, _ := chromedp.NewExecAllocator(
-			context.Background(),
-			chromedp.Headless,
-			chromedp.NoSandbox,
-		)
+		var ctx context.Context
+		if automationOptions.IsRemote() {
+			// attach to a browser that is already running elsewhere
+			logrus.Infof("Connecting to browser: %s", automationOptions.RemoteURL)
+			ctx, _ = chromedp.NewRemoteAllocator(
+				context.Background(),
+				automationOptions.RemoteURL,
+			)
+		} else {
+			ctx, _ = chromedp.NewExecAllocator(
+				context.Background(),
+				automationOptions.ExecAllocatorOptions()...,
+			)
+		}
 
 		allocCtx, _ = chromedp.NewContext(ctx)
 
 		logrus.Infof("Allocated context: %v", &allocCtx)
 
 		if err := chromedp.Run(allocCtx); err != nil {
-			logrus.Panic(err)
+			allocErr = fmt.Errorf("could not start browser: %w", err)
+			return
 		}
+	})
+	if allocErr != nil {
+		return nil, allocErr
+	}
 
-		chromedp.ListenBrowser(allocCtx, func(ev interface{}) {
-			if ev, ok := ev.(*runtime.EventExceptionThrown); ok {
-				logrus.Panicf("%+v\n", ev.ExceptionDetails)
+	tabCtx, closeTab := allocCtx, context.CancelFunc(func() {})
+	var proxyLogin *fetch.AuthChallengeResponse
+	if automationOptions.NeedsOwnContext() {
+		proxy, localProxy, err := contextProxy(automationOptions)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up proxy: %w", err)
+		}
+		contextOptions := []chromedp.CreateBrowserContextOption{}
+		if proxy != nil {
+			contextOptions = append(contextOptions, proxy)
+		}
+
+		// a new tab in a new incognito context, closed along with the automation
+		var closeContext context.CancelFunc
+		tabCtx, closeContext = chromedp.NewContext(allocCtx, chromedp.WithNewBrowserContext(contextOptions...))
+		closeTab = func() {
+			closeContext()
+			if localProxy != nil {
+				localProxy.Close()
 			}
-		})
-	})
+		}
+		actions := []chromedp.Action{}
+		if localProxy != nil && localProxy.Intercepts() {
+			// the local proxy checks the real certificates in its place, only
+			// for this tab since chrome has no such setting per context
+			actions = append(actions, security.SetIgnoreCertificateErrors(true))
+		}
+		if localProxy != nil {
+			proxyLogin = &fetch.AuthChallengeResponse{
+				Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+				Username: localProxy.Username,
+				Password: localProxy.Password,
+			}
+		}
+		if err := chromedp.Run(tabCtx, actions...); err != nil {
+			closeTab()
+			return nil, fmt.Errorf("could not create browser context: %w", err)
+		}
+	}
 
-	// create a timeout as a safety net to prevent any infinite wait loops
-	ctx, cleanup := context.WithTimeout(allocCtx, 60*time.Second)
+	// create a timeout as a safety net to prevent any infinite wait loops,
+	// shared with the other automations of the run when there is one
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if automationOptions.RunContext != nil {
+		ctx, cancel = withRunContext(tabCtx, automationOptions.RunContext)
+	} else {
+		ctx, cancel = withOptionalTimeout(tabCtx, automationOptions.Timeouts.Run)
+	}
 
 	automation := &Automation{
 		Context: ctx,
-		Cleanup: cleanup,
+		Cleanup: func() {
+			cancel()
+			closeTab()
+		},
+		Options: automationOptions,
+		scope: &deadlineScope{
+			step:    "run",
+			timeout: automationOptions.Timeouts.Run,
+			ctx:     ctx,
+		},
+		browserContext: tabCtx,
+		Log:            logrus.NewEntry(logrus.StandardLogger()),
+		proxyLogin:     proxyLogin,
+	}
+
+	if proxyLogin != nil {
+		if err := automation.answerProxy(); err != nil {
+			automation.Cleanup()
+			return nil, err
+		}
 	}
 
-	return automation
+	if automationOptions.Artifacts != nil {
+		automation.forensics = NewForensics(automationOptions.Artifacts)
+		chromedp.ListenTarget(ctx, automation.forensics.HandleEvent)
+	}
+
+	return automation, nil
+}
+
+func (a *Automation) Logger() *logrus.Entry {
+	return a.Log
 }
 
 func (a *Automation) CloseBrowser() {
 	a.Cleanup()
 }
 
+// BrowserContextID is the incognito context of an isolated automation,
+// empty when it shares the default one.
+func (a *Automation) BrowserContextID() cdp.BrowserContextID {
+	if c := chromedp.FromContext(a.browserContext); c != nil {
+		return c.BrowserContextID
+	}
+	return ""
+}
+
 func (a *Automation) SetViewportSize(width int64, height int64) error {
-	logrus.Debugf("Setting viewport size to: %dx%d", width, height)
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
+	a.Log.Debugf("Setting viewport size to: %dx%d", width, height)
+	err := a.run("set viewport size", a.Options.Timeouts.Click,
+		chromedp.Sleep(a.Options.SlowMotion),
 		chromedp.EmulateViewport(width, height),
 	)
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not set viewport size: %dx%d", width, height),
-		err)
+	if err != nil {
+		return fmt.Errorf("could not set viewport size: %dx%d: %w", width, height, err)
+	}
 
-	return err
+	return nil
 }
 
 func (a *Automation) GetLocation() url.URL {
@@ -91,194 +237,227 @@ func (a *Automation) GetLocation() url.URL {
 		chromedp.Location(&urlstr),
 	)
 	if err != nil {
-		logrus.Errorln("Could not get location: ", err)
+		a.Log.Errorln("Could not get location: ", err)
 		return url.URL{}
 	}
 	obj, err := url.Parse(urlstr)
 	if err != nil {
-		logrus.Errorln("Could not parse url: ", err)
+		a.Log.Errorln("Could not parse url: ", err)
 		return url.URL{}
 	}
 
 	return *obj
 }
 
-func (a *Automation) Goto(url string) error {
-	logrus.Debugf("Going to %s", url)
+func (a *Automation) Goto(url string) (err error) {
+	defer a.traceStep("goto", url)(&err)
+	a.Log.Debugf("Going to %s", url)
 
 	// Navigate to the url and wait for the url to change
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
+	err = a.runStep("goto", url, ErrNavigationFailed, a.Options.Timeouts.Navigate,
+		chromedp.Sleep(a.Options.SlowMotion),
 		chromedp.Navigate(url),
 		chromedp.WaitVisible("body"),
 	)
+	if err != nil {
+		return err
+	}
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not goto: %s", url),
-		err)
-
-	logrus.Debugf("Went to %s", url)
+	a.Log.Debugf("Went to %s", url)
 
-	return err
+	return nil
 }
 
-func (a *Automation) Find(selector string) error {
-	logrus.Debugf("Looking for %s", selector)
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
-		chromedp.WaitVisible(selector),
+func (a *Automation) Find(selector string) (err error) {
+	defer a.traceStep("find", selector)(&err)
+	a.Log.Debugf("Looking for %s", selector)
+	query, options, err := querySelector(selector)
+	if err != nil {
+		return NewStepError("find", selector, ErrSelectorNotFound, err)
+	}
+	err = a.runStep("find", selector, ErrSelectorNotFound, a.Options.Timeouts.Find,
+		chromedp.Sleep(a.Options.SlowMotion),
+		chromedp.WaitVisible(query, options...),
 	)
+	if err != nil {
+		return err
+	}
+	a.Log.Debugf("Found %s", selector)
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not find: %s", selector),
-		err)
-	logrus.Debugf("Found %s", selector)
-
-	return err
+	return nil
 }
 
-func (a *Automation) Click(selector string) error {
-	logrus.Debugf("Clicking %s", selector)
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
-		chromedp.Click(selector),
-	)
+// FindFirst waits for whichever of the selectors becomes visible first,
+// returning it.
+func (a *Automation) FindFirst(selectors ...string) (found string, err error) {
+	defer a.traceStep("find", strings.Join(selectors, ", "))(&err)
+	a.Log.Debugf("Looking for any of %v", selectors)
+	scope, cancel := a.pushScope(fmt.Sprintf("find any of %v", selectors), a.Options.Timeouts.Find)
+	defer cancel()
+
+	visible := make(chan string, len(selectors))
+	failed := make(chan error, len(selectors))
+	for _, selector := range selectors {
+		go func(selector string) {
+			query, options, err := querySelector(selector)
+			if err != nil {
+				failed <- err
+				return
+			}
+			err = chromedp.Run(scope.ctx,
+				chromedp.Sleep(a.Options.SlowMotion),
+				chromedp.WaitVisible(query, options...),
+			)
+			if err != nil {
+				failed <- err
+				return
+			}
+			visible <- selector
+		}(selector)
+	}
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not click: %s", selector),
-		err)
-	logrus.Debugf("Clicked %s", selector)
+	for range selectors {
+		select {
+		case selector := <-visible:
+			a.Log.Debugf("Found %s", selector)
+			return selector, nil
+		case err = <-failed:
+		}
+	}
 
-	return err
+	err = scope.wrap(err)
+	a.captureFailure(scope.step, err)
+	return "", NewStepError("find", fmt.Sprint(selectors), ErrSelectorNotFound, err)
 }
 
-func (a *Automation) Focus(selector string) error {
-	logrus.Debugf("Focusing %s", selector)
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
-		chromedp.Focus(selector),
+func (a *Automation) Click(selector string) (err error) {
+	defer a.traceStep("click", selector)(&err)
+	a.Log.Debugf("Clicking %s", selector)
+	query, options, err := querySelector(selector)
+	if err != nil {
+		return NewStepError("click", selector, ErrSelectorNotFound, err)
+	}
+	err = a.runStep("click", selector, ErrSelectorNotFound, a.Options.Timeouts.Click,
+		chromedp.Sleep(a.Options.SlowMotion),
+		chromedp.Click(query, options...),
 	)
+	if err != nil {
+		return err
+	}
+	a.Log.Debugf("Clicked %s", selector)
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not focus: %s", selector),
-		err)
-
-	logrus.Debugf("Focused %s", selector)
-	return err
+	return nil
 }
 
-func (a *Automation) Fill(selector string, value string) error {
-	logrus.Debugf("Filling %s with %s", selector, value)
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
-		chromedp.WaitVisible(selector),
-		chromedp.Sleep(1000),
-		chromedp.SetValue(selector, value),
+func (a *Automation) Focus(selector string) (err error) {
+	defer a.traceStep("focus", selector)(&err)
+	a.Log.Debugf("Focusing %s", selector)
+	query, options, err := querySelector(selector)
+	if err != nil {
+		return NewStepError("focus", selector, ErrSelectorNotFound, err)
+	}
+	err = a.runStep("focus", selector, ErrSelectorNotFound, a.Options.Timeouts.Click,
+		chromedp.Sleep(a.Options.SlowMotion),
+		chromedp.Focus(query, options...),
 	)
+	if err != nil {
+		return err
+	}
+
+	a.Log.Debugf("Focused %s", selector)
+	return nil
+}
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not fill: %s", selector),
-		err)
+func (a *Automation) Fill(selector string, value string) (err error) {
+	defer a.traceStep("fill", selector)(&err)
+	a.Log.Debugf("Filling %s with %s", selector, value)
+	query, options, err := querySelector(selector)
+	if err != nil {
+		return NewStepError("fill", selector, ErrSelectorNotFound, err)
+	}
+	err = a.runStep("fill", selector, ErrSelectorNotFound, a.Options.Timeouts.Click,
+		append(
+			[]chromedp.Action{
+				chromedp.Sleep(a.Options.SlowMotion),
+				chromedp.WaitVisible(query, options...),
+				chromedp.Sleep(1000),
+			},
+			a.inputActions(selector, query, options, value)...,
+		)...,
+	)
+	if err != nil {
+		return err
+	}
 
-	logrus.Debugf("Filled %s with %s", selector, value)
+	a.Log.Debugf("Filled %s with %s", selector, value)
 
-	return err
+	return nil
 }
 
-func (a *Automation) FillSensitive(selector string, value string) error {
+func (a *Automation) FillSensitive(selector string, value string) (err error) {
+	defer a.traceStep("fill", selector)(&err)
 	// make a string of stars the same length as the value
 	stars := Stars(value)
-	logrus.Debugf("Filling %s with %s", selector, stars)
-	err := chromedp.Run(a.Context,
-		chromedp.Sleep(100*time.Millisecond),
-		chromedp.SetValue(selector, value),
+	a.Log.Debugf("Filling %s with %s", selector, stars)
+	query, options, err := querySelector(selector)
+	if err != nil {
+		return NewStepError("fill", selector, ErrSelectorNotFound, err)
+	}
+	err = a.runStep("fill", selector, ErrSelectorNotFound, a.Options.Timeouts.Click,
+		append(
+			[]chromedp.Action{chromedp.Sleep(a.Options.SlowMotion)},
+			a.inputActions(selector, query, options, value)...,
+		)...,
 	)
+	if err != nil {
+		return err
+	}
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not fill: %s", selector),
-		err)
-
-	logrus.Debugf("Filled %s with %s", selector, stars)
+	a.Log.Debugf("Filled %s with %s", selector, stars)
 
-	return err
+	return nil
 }
 
-func (a *Automation) Pause(ms int) error {
-	logrus.Debugf("Pausing for %d sec", ms)
-	err := chromedp.Run(a.Context,
+func (a *Automation) Pause(ms int) (err error) {
+	defer a.traceStep("pause", fmt.Sprintf("%d ms", ms))(&err)
+	a.Log.Debugf("Pausing for %d ms", ms)
+	err = a.run("pause", 0,
 		chromedp.Sleep(time.Duration(ms)*time.Millisecond),
 	)
 
-	AssertErrorToNilf(
-		fmt.Sprintf("could not pause: %d sec", ms),
-		err)
+	if err != nil {
+		return fmt.Errorf("could not pause: %d ms: %w", ms, err)
+	}
 
-	logrus.Debugf("Paused for %d sec", ms)
+	a.Log.Debugf("Paused for %d ms", ms)
 
-	return err
+	return nil
 }
 
-func (a *Automation) DownloadFile(
-	downloadpath string,
-	action func() error,
-) (string, error) {
-	logrus.Debugf("Downloading: %s", downloadpath)
-
-	targetDir, targetFilename := path.Split(downloadpath)
-	storagePath := ResolveFileArg(
-		"",
-		"BANKDOWNLOADER_DOWNLOADDIR",
-		path.Join("downloads", targetDir),
-	)
-	savedFilename := path.Join(storagePath, targetFilename)
-	is_downloaded := make(chan string, 1)
-
-	// set up a listener to watch the download events and close the channel
-	// when complete this could be expanded to handle multiple downloads
-	// through creating a guid map, monitor download urls via
-	// EventDownloadWillBegin, etc
-	chromedp.ListenTarget(a.Context, func(v interface{}) {
-		ev, ok := v.(*browser.EventDownloadProgress)
-		if ok {
-			completed := "(unknown)"
-			if ev.TotalBytes != 0 {
-				completed = fmt.Sprintf("%0.2f%%", ev.ReceivedBytes/ev.TotalBytes*100.0)
-			}
-
-			log.Printf("state: %s, completed: %s\n", ev.State.String(), completed)
-			if ev.State == browser.DownloadProgressStateCompleted {
-				is_downloaded <- ev.GUID
-				close(is_downloaded)
-			}
-		}
-	})
-	logrus.Debugf("Listening for download event")
-
-	err := chromedp.Run(a.Context,
-		browser.
-			SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
-			WithDownloadPath(storagePath).
-			WithEventsEnabled(true))
-	AssertErrorToNilf("could not save file: %w", err)
-
-	err = action()
-	AssertErrorToNilf("Problem initiating download: %w", err)
-
-	downloaded := <-is_downloaded
-	downloadedPath := path.Join(storagePath, downloaded)
-
-	// check if the file exists
-	if _, err := os.Stat(downloadedPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("could not download file: %s", downloadedPath)
+// ReadAll waits for selector, then reads the text of fields in each
+// element it matches, eg: the name and number of every account listed.
+func (a *Automation) ReadAll(selector string, fields map[string]string) (entries []map[string]string, err error) {
+	defer a.traceStep("read", selector)(&err)
+	a.Log.Debugf("Reading %s", selector)
+	query, options, err := querySelector(selector)
+	if err != nil {
+		return nil, NewStepError("read", selector, ErrSelectorNotFound, err)
 	}
-
-	// move the file to the expected location
-	if err := os.Rename(downloadedPath, savedFilename); err != nil {
-		return "", fmt.Errorf("could not move file: %s", err)
+	expression, err := readAllExpression(selector, fields)
+	if err != nil {
+		return nil, NewStepError("read", selector, ErrSelectorNotFound, err)
 	}
-	logrus.Debugf("Downloaded: %s", savedFilename)
+	err = a.runStep("read", selector, ErrSelectorNotFound, a.Options.Timeouts.Find,
+		chromedp.Sleep(a.Options.SlowMotion),
+		chromedp.WaitVisible(query, options...),
+		chromedp.Evaluate(expression, &entries),
+	)
+	if err != nil {
+		return nil, err
+	}
+	a.Log.Debugf("Read %d of %s", len(entries), selector)
 
-	return savedFilename, nil
+	return entries, nil
 }
 
 var possibleChromePaths = []string{
@@ -289,12 +468,18 @@ var possibleChromePaths = []string{
 	"google-chrome-beta",
 }
 
-func FindChrome() (string, error) {
+// FindChrome looks for chrome, trying any explicit candidates before
+// the usual executable names.
+func FindChrome(candidates ...string) (string, error) {
 	var err error
 
 	// for each path, check if it exists
-	for _, path := range possibleChromePaths {
-		actualpath, err := exec.LookPath(path)
+	for _, path := range append(candidates, possibleChromePaths...) {
+		if path == "" {
+			continue
+		}
+		var actualpath string
+		actualpath, err = exec.LookPath(path)
 		if err == nil {
 			logrus.Debugf("Found chrome: %s", actualpath)
 			return actualpath, nil
@@ -305,24 +490,9 @@ func FindChrome() (string, error) {
 
 }
 
-func EnsureChromeExists() {
-	_, err := FindChrome()
-	AssertErrorToNilf("could not find chrome: %w", err)
-	if err != nil {
-		panic(err)
-	}
+// EnsureChromeExists checks chrome can be found before a browser is
+// needed, see FindChrome.
+func EnsureChromeExists(candidates ...string) error {
+	_, err := FindChrome(candidates...)
+	return err
 }
-
-// func TakeScreenshot(page playwright.Page, topic string) {
-// 	cwd := GetCwd()
-// 	if _, err := os.Stat(path.Join(cwd, "screenshots")); os.IsNotExist(err) {
-// 		os.Mkdir(path.Join(cwd, "screenshots"), 0755)
-// 	}
-// 	screenshotPath := path.Join(cwd, "screenshots", fmt.Sprintf("%s.png", slug.Make(topic)))
-// 	if _, err := page.Screenshot(playwright.PageScreenshotOptions{
-// 		Path:     playwright.String(screenshotPath),
-// 		FullPage: playwright.Bool(true),
-// 	}); err != nil {
-// 		logrus.Errorln("Could not take screenshot: ", err)
-// 	}
-// }
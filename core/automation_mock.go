@@ -0,0 +1,261 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/sirupsen/logrus"
+)
+
+// FakeCall is an action a FakeAutomation was asked to do.
+type FakeCall struct {
+	// eg: goto, find, click, fill or download
+	Action string
+	// the selector, url or download path
+	Target string
+	Value  string
+}
+
+// FakeScript runs when an action is called on a FakeAutomation, to change
+// what is on the page or to make the action fail.
+type FakeScript func(f *FakeAutomation) error
+
+// FakeAutomation is an IAutomation without a browser, for testing
+// processors. Only the selectors it is shown can be found, and scripts
+// given to On play the part of the site.
+type FakeAutomation struct {
+	Log *logrus.Entry
+	// the page the fake is on
+	Location url.URL
+	// every action asked of the fake, in order
+	Calls []FakeCall
+	// selectors filled key by key
+	Keystrokes []string
+	// written to each downloaded file
+	DownloadContent []byte
+	// what ReadAll finds, by selector
+	Entries map[string][]map[string]string
+
+	mu      sync.Mutex
+	visible map[string]bool
+	scripts map[string][]FakeScript
+}
+
+// ensure that FakeAutomation implements the IAutomation interface
+var _ IAutomation = (*FakeAutomation)(nil)
+
+func NewFakeAutomation(visible ...string) *FakeAutomation {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	f := &FakeAutomation{
+		Log:        logrus.NewEntry(logger),
+		Calls:      []FakeCall{},
+		Keystrokes: []string{},
+		Entries:    map[string][]map[string]string{},
+		visible:    map[string]bool{},
+		scripts:    map[string][]FakeScript{},
+	}
+	f.Show(visible...)
+	return f
+}
+
+// Show puts selectors on the page.
+func (f *FakeAutomation) Show(selectors ...string) *FakeAutomation {
+	for _, selector := range selectors {
+		f.visible[selector] = true
+	}
+	return f
+}
+
+// Hide takes selectors off the page.
+func (f *FakeAutomation) Hide(selectors ...string) *FakeAutomation {
+	for _, selector := range selectors {
+		delete(f.visible, selector)
+	}
+	return f
+}
+
+// On runs script whenever action is called with target, eg: showing the
+// accounts page once the login button is clicked.
+func (f *FakeAutomation) On(action string, target string, script FakeScript) *FakeAutomation {
+	key := action + " " + target
+	f.scripts[key] = append(f.scripts[key], script)
+	return f
+}
+
+// Called reports whether action was called with target.
+func (f *FakeAutomation) Called(action string, target string) bool {
+	return len(f.CallsTo(action, target)) > 0
+}
+
+// CallsTo lists the calls of action with target.
+func (f *FakeAutomation) CallsTo(action string, target string) []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := []FakeCall{}
+	for _, call := range f.Calls {
+		if call.Action == action && call.Target == target {
+			output = append(output, call)
+		}
+	}
+	return output
+}
+
+// records the call and runs its scripts
+func (f *FakeAutomation) call(action string, target string, value string) error {
+	f.mu.Lock()
+	f.Calls = append(f.Calls, FakeCall{Action: action, Target: target, Value: value})
+	scripts := f.scripts[action+" "+target]
+	f.mu.Unlock()
+
+	for _, script := range scripts {
+		if err := script(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calls action on selector, which must be on the page
+func (f *FakeAutomation) callVisible(action string, selector string, value string) error {
+	if err := f.call(action, selector, value); err != nil {
+		return err
+	}
+	if !f.visible[selector] {
+		return NewStepError(action, selector, ErrSelectorNotFound, fmt.Errorf("not on the fake page"))
+	}
+	return nil
+}
+
+func (f *FakeAutomation) Logger() *logrus.Entry {
+	return f.Log
+}
+
+func (f *FakeAutomation) GetLocation() url.URL {
+	return f.Location
+}
+
+func (f *FakeAutomation) SetViewportSize(width int64, height int64) error {
+	return f.call("set viewport size", fmt.Sprintf("%dx%d", width, height), "")
+}
+
+func (f *FakeAutomation) Goto(address string) error {
+	if err := f.call("goto", address, ""); err != nil {
+		return err
+	}
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return NewStepError("goto", address, ErrNavigationFailed, err)
+	}
+	f.Location = *parsed
+	return nil
+}
+
+func (f *FakeAutomation) Find(selector string) error {
+	return f.callVisible("find", selector, "")
+}
+
+func (f *FakeAutomation) FindFirst(selectors ...string) (string, error) {
+	for _, selector := range selectors {
+		if err := f.call("find", selector, ""); err != nil {
+			return "", err
+		}
+		if f.visible[selector] {
+			return selector, nil
+		}
+	}
+	return "", NewStepError("find", fmt.Sprint(selectors), ErrSelectorNotFound, fmt.Errorf("none on the fake page"))
+}
+
+func (f *FakeAutomation) Click(selector string) error {
+	return f.callVisible("click", selector, "")
+}
+
+func (f *FakeAutomation) Focus(selector string) error {
+	return f.callVisible("focus", selector, "")
+}
+
+func (f *FakeAutomation) Fill(selector string, value string) error {
+	return f.callVisible("fill", selector, value)
+}
+
+func (f *FakeAutomation) FillSensitive(selector string, value string) error {
+	return f.callVisible("fill", selector, value)
+}
+
+func (f *FakeAutomation) Pause(ms int) error {
+	return f.call("pause", fmt.Sprint(ms), "")
+}
+
+// ReadAll returns the Entries of selector, with only the fields asked for.
+func (f *FakeAutomation) ReadAll(selector string, fields map[string]string) ([]map[string]string, error) {
+	if err := f.callVisible("read", selector, ""); err != nil {
+		return nil, err
+	}
+	output := []map[string]string{}
+	for _, entry := range f.Entries[selector] {
+		read := map[string]string{}
+		for name := range fields {
+			read[name] = entry[name]
+		}
+		output = append(output, read)
+	}
+	return output, nil
+}
+
+// DownloadFile runs action, then writes DownloadContent to downloadpath.
+func (f *FakeAutomation) DownloadFile(downloadpath string, action func() error, mimeTypes ...string) (string, error) {
+	saved, err := f.Download(DownloadRequest{Path: downloadpath, Count: 1, MimeTypes: mimeTypes}, action)
+	if err != nil {
+		return "", err
+	}
+	return saved[0], nil
+}
+
+func (f *FakeAutomation) Download(request DownloadRequest, action func() error) ([]string, error) {
+	if err := action(); err != nil {
+		return nil, err
+	}
+	if err := f.call("download", request.Path, ""); err != nil {
+		return nil, err
+	}
+
+	count := request.Count
+	if count < 1 {
+		count = 1
+	}
+	saved := []string{}
+	for index := 0; index < count; index++ {
+		path := filepath.Join(filepath.Dir(request.Path), numberedPath(filepath.Base(request.Path), index))
+		if f.DownloadContent != nil {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return saved, NewStepError("download", path, ErrDownloadFailed, err)
+			}
+			if err := os.WriteFile(path, f.DownloadContent, 0644); err != nil {
+				return saved, NewStepError("download", path, ErrDownloadFailed, err)
+			}
+		}
+		saved = append(saved, path)
+	}
+	return saved, nil
+}
+
+// CaptureResponses returns a capture that only sees the events given to
+// its HandleEvent.
+func (f *FakeAutomation) CaptureResponses(urlPattern string) (*ResponseCapture, error) {
+	if err := f.call("capture responses", urlPattern, ""); err != nil {
+		return nil, err
+	}
+	return NewResponseCapture(urlPattern, func(id network.RequestID) ([]byte, error) {
+		return nil, fmt.Errorf("no body for %s in the fake", id)
+	})
+}
+
+func (f *FakeAutomation) UseKeystrokes(selectors ...string) {
+	f.Keystrokes = append(f.Keystrokes, selectors...)
+}
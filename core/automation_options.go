@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// AutomationOptions describes how the browser is launched and driven.
+type AutomationOptions struct {
+	// run the browser without a visible window
+	Headless bool
+	// explicit path to the chrome executable, otherwise FindChrome is used
+	ExecPath string
+	// directory used for the chrome profile, otherwise a temporary one is made
+	UserDataDir string
+	// initial window size of the browser
+	WindowWidth  int
+	WindowHeight int
+	// overrides the user agent reported by the browser
+	UserAgent string
+	// extra chrome command line switches, without the leading dashes
+	Flags map[string]interface{}
+	// delay applied before every automation action
+	SlowMotion time.Duration
+	// DevTools url of an already running browser to attach to instead of
+	// launching one, eg: ws://127.0.0.1:9222/
+	RemoteURL string
+	// how long each action, account and the whole run may take
+	Timeouts AutomationTimeouts
+	// bounds the whole run when automations share one, see WithRunContext.
+	// Otherwise each automation gets its own Timeouts.Run
+	RunContext context.Context
+	// how failing actions and account downloads are retried
+	Retries AutomationRetries
+	// how Fill puts values into fields
+	Input InputOptions
+	// where failure bundles are written, nothing is captured when nil
+	Artifacts *RunArtifacts
+	// where every step is recorded, nothing is traced when nil
+	Trace *StepTrace
+	// run in a tab of its own incognito browser context, so that cookies
+	// and downloads are not shared with other automations
+	Isolated bool
+	// the proxy the automation's traffic goes through, implies Isolated
+	Proxy *ProxyOptions
+	// presented to the hosts that ask for one, implies Isolated
+	ClientCertificates []ClientCertificate
+}
+
+type AutomationOptionator func(*AutomationOptions)
+
+func NewAutomationOptions(options ...AutomationOptionator) AutomationOptions {
+	output := AutomationOptions{
+		Headless:   true,
+		Flags:      map[string]interface{}{},
+		SlowMotion: 100 * time.Millisecond,
+		Timeouts:   DefaultAutomationTimeouts(),
+		Retries:    DefaultAutomationRetries(),
+		Input:      DefaultInputOptions(),
+	}
+	for _, option := range options {
+		option(&output)
+	}
+	return output
+}
+
+func WithHeadless(headless bool) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Headless = headless
+	}
+}
+
+func WithExecPath(path string) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.ExecPath = path
+	}
+}
+
+func WithUserDataDir(dir string) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.UserDataDir = dir
+	}
+}
+
+func WithWindowSize(width int, height int) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.WindowWidth = width
+		o.WindowHeight = height
+	}
+}
+
+func WithUserAgent(userAgent string) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.UserAgent = userAgent
+	}
+}
+
+func WithFlag(name string, value interface{}) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Flags[name] = value
+	}
+}
+
+func WithSlowMotion(delay time.Duration) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.SlowMotion = delay
+	}
+}
+
+func WithRemoteURL(url string) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.RemoteURL = url
+	}
+}
+
+func WithTimeouts(timeouts AutomationTimeouts) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Timeouts = timeouts
+	}
+}
+
+// WithRunContext bounds the automation by the deadline of the run it is
+// part of, so that automations created as the run goes share one budget.
+func WithRunContext(ctx context.Context) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.RunContext = ctx
+	}
+}
+
+func WithArtifacts(artifacts *RunArtifacts) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Artifacts = artifacts
+	}
+}
+
+func WithTrace(trace *StepTrace) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Trace = trace
+	}
+}
+
+// IsRemote reports whether the browser is attached to rather than launched.
+func (o AutomationOptions) IsRemote() bool {
+	return o.RemoteURL != ""
+}
+
+// converts the options into the arguments chromedp uses to launch chrome
+func (o AutomationOptions) ExecAllocatorOptions() []chromedp.ExecAllocatorOption {
+	output := []chromedp.ExecAllocatorOption{
+		chromedp.NoFirstRun,
+		chromedp.NoDefaultBrowserCheck,
+		chromedp.NoSandbox,
+	}
+
+	if o.Headless {
+		output = append(output, chromedp.Headless)
+	}
+
+	execPath := o.ExecPath
+	if execPath == "" {
+		execPath, _ = FindChrome()
+	}
+	if execPath != "" {
+		output = append(output, chromedp.ExecPath(execPath))
+	}
+
+	if o.UserDataDir != "" {
+		output = append(output, chromedp.UserDataDir(o.UserDataDir))
+	}
+
+	if o.WindowWidth > 0 && o.WindowHeight > 0 {
+		output = append(output, chromedp.WindowSize(o.WindowWidth, o.WindowHeight))
+	}
+
+	if o.UserAgent != "" {
+		output = append(output, chromedp.UserAgent(o.UserAgent))
+	}
+
+	for _, name := range SortedKeys(o.Flags) {
+		output = append(output, chromedp.Flag(name, o.Flags[name]))
+	}
+
+	return output
+}
+
+func WithRetries(retries AutomationRetries) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Retries = retries
+	}
+}
+
+func WithInput(input InputOptions) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Input = input
+	}
+}
+
+func WithIsolatedContext() AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Isolated = true
+	}
+}
+
+func WithProxy(proxy *ProxyOptions) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.Proxy = proxy
+	}
+}
+
+func WithClientCertificates(certificates []ClientCertificate) AutomationOptionator {
+	return func(o *AutomationOptions) {
+		o.ClientCertificates = certificates
+	}
+}
+
+// NeedsOwnContext reports whether the automation can't share a browser
+// context, since proxies are set per context.
+func (o AutomationOptions) NeedsOwnContext() bool {
+	return o.Isolated || o.Proxy != nil || len(o.ClientCertificates) > 0
+}
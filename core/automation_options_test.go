@@ -0,0 +1,35 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutomationOptionsDefaults(t *testing.T) {
+	options := NewAutomationOptions()
+
+	assert.True(t, options.Headless)
+	assert.Equal(t, 100*time.Millisecond, options.SlowMotion)
+	assert.Empty(t, options.Flags)
+}
+
+func TestAutomationOptionators(t *testing.T) {
+	options := NewAutomationOptions(
+		WithHeadless(false),
+		WithExecPath("/usr/bin/chromium"),
+		WithWindowSize(1200, 900),
+		WithFlag("lang", "en-AU"),
+		WithSlowMotion(time.Second),
+	)
+
+	assert.False(t, options.Headless)
+	assert.Equal(t, "/usr/bin/chromium", options.ExecPath)
+	assert.Equal(t, 1200, options.WindowWidth)
+	assert.Equal(t, 900, options.WindowHeight)
+	assert.Equal(t, "en-AU", options.Flags["lang"])
+	assert.Equal(t, time.Second, options.SlowMotion)
+	// no-first-run, no-default-browser-check, no-sandbox, exec path, window size, lang
+	assert.Len(t, options.ExecAllocatorOptions(), 6)
+}
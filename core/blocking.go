@@ -0,0 +1,312 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/sirupsen/logrus"
+)
+
+// trackers and ads that banking portals load but never need to work
+var DefaultBlockedURLs = []string{
+	"*://*.google-analytics.com/*",
+	"*://*.googletagmanager.com/*",
+	"*://*.doubleclick.net/*",
+	"*://*.googleadservices.com/*",
+	"*://*.facebook.net/*",
+	"*://*.hotjar.com/*",
+	"*://*.quantummetric.com/*",
+	"*://*.demdex.net/*",
+	"*://*.omtrdc.net/*",
+	"*://*.adnxs.com/*",
+	"*://bat.bing.com/*",
+}
+
+// BlockRules decides which requests the browser is not allowed to make.
+type BlockRules struct {
+	// url patterns where * matches anything
+	URLs []string
+	// kinds of resource, eg: Image or Font
+	ResourceTypes []network.ResourceType
+
+	patterns []*regexp.Regexp
+}
+
+// NewBlockRules checks the url patterns and resource type names, which are
+// matched regardless of case.
+func NewBlockRules(urls []string, resourceTypes []string) (BlockRules, error) {
+	rules := BlockRules{
+		URLs:          urls,
+		ResourceTypes: []network.ResourceType{},
+		patterns:      []*regexp.Regexp{},
+	}
+	for _, url := range urls {
+		pattern, err := WildcardPattern(url)
+		if err != nil {
+			return BlockRules{}, fmt.Errorf("could not block %s: %w", url, err)
+		}
+		rules.patterns = append(rules.patterns, pattern)
+	}
+	for _, name := range resourceTypes {
+		resourceType, err := ParseResourceType(name)
+		if err != nil {
+			return BlockRules{}, err
+		}
+		rules.ResourceTypes = append(rules.ResourceTypes, resourceType)
+	}
+	return rules, nil
+}
+
+// DefaultBlockRules blocks well known trackers and ads, which is safe for
+// any source.
+func DefaultBlockRules() BlockRules {
+	rules, _ := NewBlockRules(DefaultBlockedURLs, nil)
+	return rules
+}
+
+// the resource types that can be blocked, documents never are since
+// navigating and downloading rely on them
+var blockableResourceTypes = []network.ResourceType{
+	network.ResourceTypeStylesheet,
+	network.ResourceTypeImage,
+	network.ResourceTypeMedia,
+	network.ResourceTypeFont,
+	network.ResourceTypeScript,
+	network.ResourceTypeTextTrack,
+	network.ResourceTypeXHR,
+	network.ResourceTypeFetch,
+	network.ResourceTypePrefetch,
+	network.ResourceTypeEventSource,
+	network.ResourceTypeWebSocket,
+	network.ResourceTypeManifest,
+	network.ResourceTypePing,
+	network.ResourceTypeCSPViolationReport,
+	network.ResourceTypeOther,
+}
+
+func ParseResourceType(name string) (network.ResourceType, error) {
+	names := []string{}
+	for _, resourceType := range blockableResourceTypes {
+		if strings.EqualFold(name, string(resourceType)) {
+			return resourceType, nil
+		}
+		names = append(names, strings.ToLower(string(resourceType)))
+	}
+	return "", fmt.Errorf("can not block resource type: %s, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// IsEmpty reports whether nothing is blocked.
+func (r BlockRules) IsEmpty() bool {
+	return len(r.patterns) == 0 && len(r.ResourceTypes) == 0
+}
+
+// Blocks reports whether a request for url of the given type is blocked.
+func (r BlockRules) Blocks(url string, resourceType network.ResourceType) bool {
+	for _, blocked := range r.ResourceTypes {
+		if blocked == resourceType {
+			return true
+		}
+	}
+	for _, pattern := range r.patterns {
+		if pattern.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// the requests the browser pauses for the rules to decide on
+func (r BlockRules) requestPatterns() []*fetch.RequestPattern {
+	patterns := []*fetch.RequestPattern{}
+	for _, url := range r.URLs {
+		patterns = append(patterns, &fetch.RequestPattern{URLPattern: url})
+	}
+	for _, resourceType := range r.ResourceTypes {
+		patterns = append(patterns, &fetch.RequestPattern{URLPattern: "*", ResourceType: resourceType})
+	}
+	return patterns
+}
+
+// RequestBlocker fails the requests its rules block, and lets the rest
+// carry on.
+type RequestBlocker struct {
+	Rules BlockRules
+	// tells the browser what to do with a paused request
+	resolve func(id fetch.RequestID, block bool) error
+
+	mu       sync.Mutex
+	blocked  int
+	errs     []error
+	stopped  bool
+	resolves sync.WaitGroup
+	stop     func() error
+	cancel   context.CancelFunc
+}
+
+func NewRequestBlocker(rules BlockRules, resolve func(id fetch.RequestID, block bool) error) *RequestBlocker {
+	return &RequestBlocker{
+		Rules:   rules,
+		resolve: resolve,
+	}
+}
+
+// BlockRequests stops the browser tab making the requests rules block,
+// until the returned blocker is stopped.
+func (a *Automation) BlockRequests(rules BlockRules) (*RequestBlocker, error) {
+	ctx, cancel := context.WithCancel(a.Context)
+	blocker := NewRequestBlocker(rules, func(id fetch.RequestID, block bool) error {
+		if block {
+			return chromedp.Run(ctx, fetch.FailRequest(id, network.ErrorReasonBlockedByClient))
+		}
+		return chromedp.Run(ctx, fetch.ContinueRequest(id))
+	})
+	blocker.cancel = cancel
+	blocker.stop = func() error {
+		// the run context may be over by the time blocking stops
+		ctx, cancel := context.WithTimeout(a.browserContext, 15*time.Second)
+		defer cancel()
+		return chromedp.Run(ctx, fetch.Disable())
+	}
+
+	if rules.IsEmpty() {
+		return blocker, nil
+	}
+
+	if a.proxyLogin != nil {
+		// every request is paused already, to answer the local proxy
+		a.setBlocker(blocker)
+		blocker.stop = func() error {
+			a.setBlocker(nil)
+			return nil
+		}
+		a.Log.Debugf("Blocking %d url patterns and %d resource types", len(rules.URLs), len(rules.ResourceTypes))
+		return blocker, nil
+	}
+
+	chromedp.ListenTarget(ctx, blocker.HandleEvent)
+	err := chromedp.Run(a.Context, fetch.Enable().WithPatterns(rules.requestPatterns()))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("could not block requests: %w", err)
+	}
+	a.Log.Debugf("Blocking %d url patterns and %d resource types", len(rules.URLs), len(rules.ResourceTypes))
+	return blocker, nil
+}
+
+func (a *Automation) setBlocker(blocker *RequestBlocker) {
+	a.blockerMu.Lock()
+	defer a.blockerMu.Unlock()
+	a.blocker = blocker
+}
+
+// answerProxy logs the browser tab into the local proxy whenever it asks.
+// Chrome only asks for proxy logins of requests the fetch domain pauses, so
+// every request is paused and let through, unless a blocker decides on it.
+func (a *Automation) answerProxy() error {
+	chromedp.ListenTarget(a.browserContext, a.handleProxyEvent)
+	err := chromedp.Run(a.browserContext, fetch.Enable().
+		WithHandleAuthRequests(true).
+		WithPatterns([]*fetch.RequestPattern{{URLPattern: "*"}}))
+	if err != nil {
+		return fmt.Errorf("could not log into local proxy: %w", err)
+	}
+	return nil
+}
+
+func (a *Automation) handleProxyEvent(v interface{}) {
+	var action chromedp.Action
+	switch ev := v.(type) {
+	case *fetch.EventAuthRequired:
+		response := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+		// sites asking for a login are left to the browser
+		if ev.AuthChallenge.Source == fetch.AuthChallengeSourceProxy {
+			response = a.proxyLogin
+		}
+		action = fetch.ContinueWithAuth(ev.RequestID, response)
+	case *fetch.EventRequestPaused:
+		a.blockerMu.Lock()
+		blocker := a.blocker
+		a.blockerMu.Unlock()
+		if blocker != nil {
+			blocker.HandleEvent(ev)
+			return
+		}
+		action = fetch.ContinueRequest(ev.RequestID)
+	default:
+		return
+	}
+
+	// listeners must not block, so the browser is answered elsewhere
+	go func() {
+		if err := chromedp.Run(a.browserContext, action); err != nil {
+			logrus.Debugf("could not answer paused request: %v", err)
+		}
+	}()
+}
+
+// HandleEvent decides on paused requests, give it to chromedp.ListenTarget
+func (b *RequestBlocker) HandleEvent(v interface{}) {
+	ev, ok := v.(*fetch.EventRequestPaused)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// requests paused while stopping are let through, never left hanging
+	block := !b.stopped && b.Rules.Blocks(ev.Request.URL, ev.ResourceType)
+	if block {
+		b.blocked++
+	}
+
+	// listeners must not block, so the browser is answered elsewhere
+	b.resolves.Add(1)
+	go func(id fetch.RequestID, url string) {
+		defer b.resolves.Done()
+		if err := b.resolve(id, block); err != nil {
+			b.mu.Lock()
+			b.errs = append(b.errs, fmt.Errorf("could not resolve request for %s: %w", url, err))
+			b.mu.Unlock()
+		}
+	}(ev.RequestID, ev.Request.URL)
+}
+
+// Blocked is how many requests were blocked so far.
+func (b *RequestBlocker) Blocked() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.blocked
+}
+
+// Stop lets every request through again.
+func (b *RequestBlocker) Stop() error {
+	b.mu.Lock()
+	wasStopped := b.stopped
+	b.stopped = true
+	b.mu.Unlock()
+	if wasStopped {
+		return nil
+	}
+
+	var err error
+	if b.stop != nil && !b.Rules.IsEmpty() {
+		err = b.stop()
+	}
+	b.resolves.Wait()
+	if b.cancel != nil {
+		b.cancel()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return errors.Join(append([]error{err}, b.errs...)...)
+}
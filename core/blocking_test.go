@@ -0,0 +1,96 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockRulesBlocks(t *testing.T) {
+	rules, err := NewBlockRules([]string{"*://*.tracker.example/*"}, []string{"font", "Image"})
+	assert.NoError(t, err)
+
+	assert.True(t, rules.Blocks("https://cdn.tracker.example/pixel.js", network.ResourceTypeScript))
+	assert.True(t, rules.Blocks("https://bank.example/logo.png", network.ResourceTypeImage))
+	assert.True(t, rules.Blocks("https://bank.example/sans.woff2", network.ResourceTypeFont))
+	assert.False(t, rules.Blocks("https://bank.example/app.js", network.ResourceTypeScript))
+	assert.False(t, rules.Blocks("https://tracker.example.bank.example/", network.ResourceTypeDocument))
+}
+
+func TestNewBlockRulesRejectsDocuments(t *testing.T) {
+	_, err := NewBlockRules(nil, []string{"document"})
+	assert.Error(t, err)
+
+	_, err = NewBlockRules(nil, []string{"tracker"})
+	assert.Error(t, err)
+}
+
+func TestDefaultBlockRulesBlockTrackers(t *testing.T) {
+	rules := DefaultBlockRules()
+	assert.False(t, rules.IsEmpty())
+	assert.True(t, rules.Blocks("https://www.google-analytics.com/collect?v=1", network.ResourceTypePing))
+	assert.False(t, rules.Blocks("https://www.anz.com/INETBANK/login.asp", network.ResourceTypeDocument))
+}
+
+func TestRequestBlockerResolvesPausedRequests(t *testing.T) {
+	rules, err := NewBlockRules([]string{"*://ads.example/*"}, nil)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	decisions := map[fetch.RequestID]bool{}
+	blocker := NewRequestBlocker(rules, func(id fetch.RequestID, block bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		decisions[id] = block
+		return nil
+	})
+
+	blocker.HandleEvent(&fetch.EventRequestPaused{
+		RequestID:    "1",
+		Request:      &network.Request{URL: "https://ads.example/banner.js"},
+		ResourceType: network.ResourceTypeScript,
+	})
+	blocker.HandleEvent(&fetch.EventRequestPaused{
+		RequestID:    "2",
+		Request:      &network.Request{URL: "https://bank.example/api/accounts"},
+		ResourceType: network.ResourceTypeXHR,
+	})
+	assert.NoError(t, blocker.Stop())
+
+	// paused after stopping, let through whatever the rules say
+	blocker.HandleEvent(&fetch.EventRequestPaused{
+		RequestID:    "3",
+		Request:      &network.Request{URL: "https://ads.example/late.js"},
+		ResourceType: network.ResourceTypeScript,
+	})
+	blocker.resolves.Wait()
+
+	assert.Equal(t, map[fetch.RequestID]bool{"1": true, "2": false, "3": false}, decisions)
+	assert.Equal(t, 1, blocker.Blocked())
+}
+
+func TestProxyLoginHandsPausedRequestsToBlocker(t *testing.T) {
+	rules, err := NewBlockRules([]string{"*://ads.example/*"}, nil)
+	assert.NoError(t, err)
+
+	decisions := make(chan bool, 1)
+	blocker := NewRequestBlocker(rules, func(id fetch.RequestID, block bool) error {
+		decisions <- block
+		return nil
+	})
+	automation := &Automation{
+		proxyLogin: &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseProvideCredentials},
+	}
+	automation.setBlocker(blocker)
+
+	automation.handleProxyEvent(&fetch.EventRequestPaused{
+		RequestID:    "1",
+		Request:      &network.Request{URL: "https://ads.example/banner.js"},
+		ResourceType: network.ResourceTypeScript,
+	})
+	assert.True(t, <-decisions)
+	assert.Equal(t, 1, blocker.Blocked())
+}
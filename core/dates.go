@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"log"
 	"time"
 )
@@ -19,18 +20,40 @@ func SetNow(date string) {
 	now = &t
 }
 
+// LoadLocation finds an IANA timezone, eg: Australia/Adelaide, defaulting to
+// the local one when empty.
+func LoadLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone: %s: %w", timezone, err)
+	}
+	return location, nil
+}
+
 // gets todays date as time
 func GetToday() time.Time {
-	return ToStartOfDay(Now())
+	return GetTodayIn(time.Local)
+}
+
+// gets todays date in location, which may not be today where we are
+func GetTodayIn(location *time.Location) time.Time {
+	return ToStartOfDayIn(Now().In(location), location)
 }
 
 func GetDaysAgo(fromDate time.Time, days int) time.Time {
 
-	return ToStartOfDay(fromDate.AddDate(0, 0, -days))
+	return ToStartOfDayIn(fromDate.AddDate(0, 0, -days), fromDate.Location())
 }
 
 func GetTodayMinusDays(days int) time.Time {
-	return GetDaysAgo(Now(), days)
+	return GetTodayMinusDaysIn(time.Local, days)
+}
+
+func GetTodayMinusDaysIn(location *time.Location, days int) time.Time {
+	return GetDaysAgo(GetTodayIn(location), days)
 }
 
 func GetDaysBetweenDates(start time.Time, end time.Time) int {
@@ -45,13 +68,23 @@ func Now() time.Time {
 }
 
 func ToStartOfDay(date time.Time) time.Time {
-	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
+	return ToStartOfDayIn(date, time.Local)
+}
+
+// the start of the calendar day of date, in location
+func ToStartOfDayIn(date time.Time, location *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
 }
 
 func StringToDate(date string, format string) time.Time {
-	t, err := time.ParseInLocation(format, date, time.Local)
+	return StringToDateIn(date, format, time.Local)
+}
+
+// parses date, keeping only its calendar day in location
+func StringToDateIn(date string, format string, location *time.Location) time.Time {
+	t, err := time.ParseInLocation(format, date, location)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	return ToStartOfDayIn(t, location)
 }
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// DownloadRequest describes the files an action is expected to download.
+type DownloadRequest struct {
+	// where the first file is saved, later ones get a numbered suffix
+	Path string
+	// how many files the action downloads, defaults to 1
+	Count int
+	// accepted content types, as sniffed from the file, eg: text/plain.
+	// Any type is accepted when empty
+	MimeTypes []string
+}
+
+// a download the browser announced, followed by guid
+type trackedDownload struct {
+	GUID              string
+	URL               string
+	SuggestedFilename string
+	State             browser.DownloadProgressState
+	ReceivedBytes     float64
+	TotalBytes        float64
+}
+
+// DownloadTracker follows the downloads started while it listens, telling
+// them apart by the guid the browser gives each one.
+type DownloadTracker struct {
+	mu        sync.Mutex
+	downloads map[string]*trackedDownload
+	order     []string
+	// signalled whenever a download finishes
+	changed chan struct{}
+}
+
+func NewDownloadTracker() *DownloadTracker {
+	return &DownloadTracker{
+		downloads: map[string]*trackedDownload{},
+		order:     []string{},
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+// HandleEvent records download events, give it to chromedp.ListenTarget
+func (t *DownloadTracker) HandleEvent(v interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch ev := v.(type) {
+	case *browser.EventDownloadWillBegin:
+		if _, ok := t.downloads[ev.GUID]; ok {
+			return
+		}
+		t.downloads[ev.GUID] = &trackedDownload{
+			GUID:              ev.GUID,
+			URL:               ev.URL,
+			SuggestedFilename: ev.SuggestedFilename,
+			State:             browser.DownloadProgressStateInProgress,
+		}
+		t.order = append(t.order, ev.GUID)
+
+	case *browser.EventDownloadProgress:
+		// downloads that began before we listened are someone else's
+		download, ok := t.downloads[ev.GUID]
+		if !ok {
+			return
+		}
+		download.State = ev.State
+		download.ReceivedBytes = ev.ReceivedBytes
+		download.TotalBytes = ev.TotalBytes
+		if ev.State != browser.DownloadProgressStateInProgress {
+			select {
+			case t.changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Wait blocks until count downloads completed, failing as soon as one is
+// canceled or ctx is done. Downloads are returned in the order they began.
+func (t *DownloadTracker) Wait(ctx context.Context, count int) ([]trackedDownload, error) {
+	for {
+		t.mu.Lock()
+		completed := []trackedDownload{}
+		var canceled *trackedDownload
+		for _, guid := range t.order {
+			download := t.downloads[guid]
+			switch download.State {
+			case browser.DownloadProgressStateCompleted:
+				completed = append(completed, *download)
+			case browser.DownloadProgressStateCanceled:
+				canceled = download
+			}
+		}
+		t.mu.Unlock()
+
+		if canceled != nil {
+			return completed, fmt.Errorf("%w: %s was canceled", ErrDownloadFailed, canceled.SuggestedFilename)
+		}
+		if len(completed) >= count {
+			return completed[:count], nil
+		}
+
+		select {
+		case <-t.changed:
+		case <-ctx.Done():
+			return completed, ctx.Err()
+		}
+	}
+}
+
+// numbers every file after the first, eg: statement-2.csv
+func numberedPath(filename string, index int) string {
+	if index == 0 {
+		return filename
+	}
+	ext := path.Ext(filename)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filename, ext), index+1, ext)
+}
+
+// checks a downloaded file has content of an accepted type
+func checkDownloadedFile(filename string, mimeTypes []string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrDownloadFailed, path.Base(filename))
+	}
+	if len(mimeTypes) == 0 {
+		return nil
+	}
+
+	detected := http.DetectContentType(head[:n])
+	for _, mimeType := range mimeTypes {
+		if strings.HasPrefix(detected, mimeType) {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"%w: %s is %s, expected %s",
+		ErrDownloadFailed, path.Base(filename), detected, strings.Join(mimeTypes, " or "),
+	)
+}
+
+// Download runs action and waits for the files it downloads, checking each
+// before moving it into place. Returns where the files were saved.
+func (a *Automation) Download(request DownloadRequest, action func() error) (saved []string, err error) {
+	defer a.traceStep("download", request.Path)(&err)
+	count := request.Count
+	if count < 1 {
+		count = 1
+	}
+	a.Log.Debugf("Downloading %d file(s): %s", count, request.Path)
+
+	targetDir, targetFilename := path.Split(request.Path)
+	storagePath := ResolveFileArg(
+		"",
+		"BANKDOWNLOADER_DOWNLOADDIR",
+		path.Join("downloads", targetDir),
+	)
+
+	// isolated automations download into a directory of their own
+	downloadDir := storagePath
+	if id := a.BrowserContextID(); id != "" {
+		downloadDir = path.Join(storagePath, ".downloads-"+string(id))
+		defer os.RemoveAll(downloadDir)
+	}
+
+	// stop listening once done, rather than for the rest of the run
+	listenCtx, stopListening := context.WithCancel(a.Context)
+	defer stopListening()
+	tracker := NewDownloadTracker()
+	chromedp.ListenTarget(listenCtx, tracker.HandleEvent)
+
+	err = chromedp.Run(a.Context,
+		browser.
+			SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
+			WithBrowserContextID(a.BrowserContextID()).
+			WithDownloadPath(downloadDir).
+			WithEventsEnabled(true))
+	if err != nil {
+		return nil, fmt.Errorf("could not save file: %w", err)
+	}
+
+	if err := action(); err != nil {
+		return nil, fmt.Errorf("problem initiating download: %w", err)
+	}
+
+	waitScope, cancel := a.pushScope("download "+request.Path, a.Options.Timeouts.Download)
+	defer cancel()
+
+	downloads, err := tracker.Wait(waitScope.ctx, count)
+	if err != nil {
+		kind := ErrDownloadFailed
+		if waitScope.ctx.Err() != nil {
+			kind = ErrDownloadTimedOut
+			err = waitScope.wrap(err)
+		}
+		a.captureFailure(waitScope.step, err)
+		return nil, NewStepError("download", request.Path, kind, err)
+	}
+
+	if err := os.MkdirAll(storagePath, 0750); err != nil {
+		return nil, fmt.Errorf("could not create download directory: %w", err)
+	}
+
+	saved = []string{}
+	for index, download := range downloads {
+		downloadedPath := path.Join(downloadDir, download.GUID)
+		if err := checkDownloadedFile(downloadedPath, request.MimeTypes); err != nil {
+			os.Remove(downloadedPath)
+			return saved, NewStepError("download", request.Path, ErrDownloadFailed, err)
+		}
+
+		// move the file to the expected location
+		savedFilename := path.Join(storagePath, numberedPath(targetFilename, index))
+		if err := os.Rename(downloadedPath, savedFilename); err != nil {
+			return saved, fmt.Errorf("could not move file: %w", err)
+		}
+		a.Log.Debugf("Downloaded %s as %s", download.SuggestedFilename, savedFilename)
+		saved = append(saved, savedFilename)
+	}
+
+	return saved, nil
+}
+
+// DownloadFile runs action and waits for the single file it downloads,
+// optionally checking its content type.
+func (a *Automation) DownloadFile(
+	downloadpath string,
+	action func() error,
+	mimeTypes ...string,
+) (string, error) {
+	saved, err := a.Download(DownloadRequest{
+		Path:      downloadpath,
+		Count:     1,
+		MimeTypes: mimeTypes,
+	}, action)
+	if err != nil {
+		return "", err
+	}
+	return saved[0], nil
+}
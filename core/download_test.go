@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadTrackerWaitsForEveryFile(t *testing.T) {
+	tracker := NewDownloadTracker()
+
+	// finished before the tracker knew about it, so not ours
+	tracker.HandleEvent(&browser.EventDownloadProgress{GUID: "old", State: browser.DownloadProgressStateCompleted})
+
+	tracker.HandleEvent(&browser.EventDownloadWillBegin{GUID: "a", SuggestedFilename: "first.csv"})
+	tracker.HandleEvent(&browser.EventDownloadWillBegin{GUID: "b", SuggestedFilename: "second.csv"})
+
+	go func() {
+		tracker.HandleEvent(&browser.EventDownloadProgress{GUID: "b", State: browser.DownloadProgressStateCompleted})
+		tracker.HandleEvent(&browser.EventDownloadProgress{GUID: "a", State: browser.DownloadProgressStateCompleted})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	downloads, err := tracker.Wait(ctx, 2)
+
+	assert.NoError(t, err)
+	assert.Len(t, downloads, 2)
+	assert.Equal(t, "a", downloads[0].GUID)
+	assert.Equal(t, "b", downloads[1].GUID)
+}
+
+func TestDownloadTrackerFailsOnCanceledDownload(t *testing.T) {
+	tracker := NewDownloadTracker()
+	tracker.HandleEvent(&browser.EventDownloadWillBegin{GUID: "a", SuggestedFilename: "statement.csv"})
+	tracker.HandleEvent(&browser.EventDownloadProgress{GUID: "a", State: browser.DownloadProgressStateCanceled})
+
+	_, err := tracker.Wait(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrDownloadFailed)
+	assert.ErrorContains(t, err, "statement.csv was canceled")
+
+	tracker = NewDownloadTracker()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = tracker.Wait(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCheckDownloadedFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0640))
+		return filename
+	}
+
+	csv := write("statement.csv", "Date,Amount,Description\n01/11/2023,-4.50,COFFEE\n")
+	assert.NoError(t, checkDownloadedFile(csv, []string{"text/plain"}))
+	assert.NoError(t, checkDownloadedFile(csv, nil))
+
+	empty := write("empty.csv", "")
+	assert.ErrorIs(t, checkDownloadedFile(empty, nil), ErrDownloadFailed)
+
+	html := write("login.csv", "<!DOCTYPE html><html><body>Please log in</body></html>")
+	err := checkDownloadedFile(html, []string{"text/plain", "text/xml"})
+	assert.ErrorIs(t, err, ErrDownloadFailed)
+	assert.ErrorContains(t, err, "login.csv is text/html")
+
+	assert.Equal(t, "statement.csv", numberedPath("statement.csv", 0))
+	assert.Equal(t, "statement-2.csv", numberedPath("statement.csv", 1))
+}
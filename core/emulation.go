@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/text/language"
+)
+
+// Geolocation is where the browser reports it is, to pages that ask.
+type Geolocation struct {
+	Latitude  float64
+	Longitude float64
+	// in metres
+	Accuracy float64
+}
+
+// Emulation is the timezone, locale and location the browser pretends to
+// be in, so that pages render the same wherever the tool runs.
+type Emulation struct {
+	// an IANA timezone, eg: Australia/Adelaide. The host's when empty
+	Timezone string
+	// a BCP 47 language tag, eg: en-AU. The host's when empty
+	Locale string
+	// no location is reported when nil
+	Geolocation *Geolocation
+}
+
+// NewEmulation checks the timezone and locale are ones the browser knows.
+func NewEmulation(timezone string, locale string, geolocation *Geolocation) (Emulation, error) {
+	if _, err := LoadLocation(timezone); err != nil {
+		return Emulation{}, err
+	}
+	if locale != "" {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return Emulation{}, fmt.Errorf("unknown locale: %s: %w", locale, err)
+		}
+		locale = tag.String()
+	}
+	if geolocation != nil {
+		if geolocation.Latitude < -90 || geolocation.Latitude > 90 || geolocation.Longitude < -180 || geolocation.Longitude > 180 {
+			return Emulation{}, fmt.Errorf("geolocation out of range: %f, %f", geolocation.Latitude, geolocation.Longitude)
+		}
+	}
+	return Emulation{
+		Timezone:    timezone,
+		Locale:      locale,
+		Geolocation: geolocation,
+	}, nil
+}
+
+// Location is where dates are worked out for the emulated timezone.
+func (e Emulation) Location() *time.Location {
+	location, err := LoadLocation(e.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// Emulate applies the emulation to the browser tab, undoing whatever a
+// previous one set that this one leaves empty.
+func (a *Automation) Emulate(e Emulation) error {
+	a.Log.Debugf("Emulating timezone: %q, locale: %q", e.Timezone, e.Locale)
+
+	err := chromedp.Run(a.Context,
+		emulation.SetTimezoneOverride(e.Timezone),
+		// a locale can only be set while none is
+		emulation.SetLocaleOverride(),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if e.Locale == "" {
+				return nil
+			}
+			return emulation.SetLocaleOverride().WithLocale(e.Locale).Do(ctx)
+		}),
+		// pages read the language from the request headers too
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			userAgent := a.Options.UserAgent
+			if userAgent == "" {
+				_, _, _, agent, _, err := browser.GetVersion().Do(ctx)
+				if err != nil {
+					return err
+				}
+				userAgent = agent
+			}
+			return emulation.SetUserAgentOverride(userAgent).WithAcceptLanguage(e.Locale).Do(ctx)
+		}),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if e.Geolocation == nil {
+				return emulation.ClearGeolocationOverride().Do(ctx)
+			}
+			err := browser.GrantPermissions([]browser.PermissionType{browser.PermissionTypeGeolocation}).
+				WithBrowserContextID(a.BrowserContextID()).
+				Do(ctx)
+			if err != nil {
+				return err
+			}
+			return emulation.SetGeolocationOverride().
+				WithLatitude(e.Geolocation.Latitude).
+				WithLongitude(e.Geolocation.Longitude).
+				WithAccuracy(e.Geolocation.Accuracy).
+				Do(ctx)
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("could not emulate timezone %q and locale %q: %w", e.Timezone, e.Locale, err)
+	}
+	return nil
+}
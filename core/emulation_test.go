@@ -0,0 +1,42 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEmulation(t *testing.T) {
+	emulation, err := NewEmulation("Australia/Adelaide", "en-au", &Geolocation{Latitude: -34.93, Longitude: 138.6, Accuracy: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, "en-AU", emulation.Locale)
+	assert.Equal(t, "Australia/Adelaide", emulation.Location().String())
+
+	emulation, err = NewEmulation("", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Local, emulation.Location())
+
+	_, err = NewEmulation("Australia/Nowhere", "", nil)
+	assert.Error(t, err)
+	_, err = NewEmulation("", "not a locale!", nil)
+	assert.Error(t, err)
+	_, err = NewEmulation("", "", &Geolocation{Latitude: 123})
+	assert.Error(t, err)
+}
+
+func TestGetTodayMinusDaysIn(t *testing.T) {
+	defer func() { now = nil }()
+	adelaide, err := LoadLocation("Australia/Adelaide")
+	assert.NoError(t, err)
+
+	// late evening in UTC is already tomorrow in Adelaide
+	at := time.Date(2023, 11, 18, 22, 0, 0, 0, time.UTC)
+	now = &at
+
+	assert.Equal(t, time.Date(2023, 11, 18, 0, 0, 0, 0, adelaide), GetTodayMinusDaysIn(adelaide, 1))
+	assert.Equal(t, time.Date(2023, 11, 17, 0, 0, 0, 0, time.UTC), GetTodayMinusDaysIn(time.UTC, 1))
+
+	saved := StringToDateIn("2023-11-18T00:00:00+10:30", time.RFC3339, adelaide)
+	assert.Equal(t, time.Date(2023, 11, 18, 0, 0, 0, 0, adelaide), saved)
+}
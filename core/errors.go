@@ -0,0 +1,69 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of failure that can end an account or source early.
+// Test for them with errors.Is.
+var (
+	ErrSelectorNotFound      = errors.New("selector not found")
+	ErrFrameUnreachable      = errors.New("cross-origin frame not reachable")
+	ErrNodeDetached          = errors.New("node detached")
+	ErrNavigationFailed      = errors.New("navigation failed")
+	ErrLoginFailed           = errors.New("login failed")
+	ErrDownloadTimedOut      = errors.New("download timed out")
+	ErrDownloadFailed        = errors.New("download failed")
+	ErrCredentialsUnresolved = errors.New("credentials unresolved")
+	ErrUnsupportedSource     = errors.New("unsupported source")
+	ErrUnsupportedFormat     = errors.New("unsupported export format")
+	ErrDeadlineExceeded      = errors.New("deadline exceeded")
+	ErrSessionExpired        = errors.New("saved session expired")
+	ErrPageException         = errors.New("page threw an exception")
+)
+
+// StepError describes an automation action that could not be completed.
+type StepError struct {
+	// the automation action, eg: click
+	Action string
+	// the selector or url the action was given
+	Target string
+	// one of the Err* kinds above
+	Kind error
+	// the underlying error from the browser
+	Err error
+}
+
+func NewStepError(action string, target string, kind error, err error) *StepError {
+	return &StepError{
+		Action: action,
+		Target: target,
+		Kind:   kind,
+		Err:    err,
+	}
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("could not %s: %s: %s", e.Action, e.Target, e.Err)
+}
+
+func (e *StepError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// TimeoutError names the step, account or run that ran out of time.
+type TimeoutError struct {
+	Step    string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s ran out of time after %s", e.Step, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() []error {
+	return []error{ErrDeadlineExceeded, e.Err}
+}
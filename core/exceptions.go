@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// ExceptionAction is what happens when a script on the page throws.
+type ExceptionAction string
+
+const (
+	ExceptionActionIgnore ExceptionAction = "ignore"
+	// warns in the log
+	ExceptionActionLog ExceptionAction = "log"
+	// warns in the log and lists it in the run summary
+	ExceptionActionReport ExceptionAction = "report"
+	// fails the step that is running, or the next one to run
+	ExceptionActionFail ExceptionAction = "fail"
+)
+
+var exceptionActions = []ExceptionAction{
+	ExceptionActionIgnore,
+	ExceptionActionLog,
+	ExceptionActionReport,
+	ExceptionActionFail,
+}
+
+func ParseExceptionAction(action string) (ExceptionAction, error) {
+	if action == "" {
+		return ExceptionActionLog, nil
+	}
+	names := []string{}
+	for _, known := range exceptionActions {
+		if ExceptionAction(action) == known {
+			return known, nil
+		}
+		names = append(names, string(known))
+	}
+	return "", fmt.Errorf("unknown exception action: %s, expected one of %s", action, strings.Join(names, ", "))
+}
+
+// PageException is an error thrown by a script on the page and not caught.
+type PageException struct {
+	Time time.Time
+	// the message, eg: TypeError: x is undefined
+	Text string
+	// the script that threw
+	URL    string
+	Line   int64
+	Column int64
+}
+
+func NewPageException(details *runtime.ExceptionDetails) PageException {
+	text := details.Text
+	if details.Exception != nil && details.Exception.Description != "" {
+		// the description carries the message and the stack
+		text = strings.SplitN(details.Exception.Description, "\n", 2)[0]
+	}
+	return PageException{
+		Time:   time.Now(),
+		Text:   text,
+		URL:    details.URL,
+		Line:   details.LineNumber + 1,
+		Column: details.ColumnNumber + 1,
+	}
+}
+
+func (e PageException) String() string {
+	if e.URL == "" {
+		return e.Text
+	}
+	return fmt.Sprintf("%s at %s:%d:%d", e.Text, e.URL, e.Line, e.Column)
+}
+
+// ExceptionPolicy decides what happens to exceptions thrown on the page.
+type ExceptionPolicy struct {
+	Action ExceptionAction
+	// exceptions whose text or script url match are ignored
+	Allow []string
+	// only exceptions whose text or script url match are acted on, all of
+	// them when empty
+	Deny []string
+
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// NewExceptionPolicy checks the action and the allow and deny patterns,
+// where * matches anything.
+func NewExceptionPolicy(action string, allow []string, deny []string) (ExceptionPolicy, error) {
+	parsed, err := ParseExceptionAction(action)
+	if err != nil {
+		return ExceptionPolicy{}, err
+	}
+	policy := ExceptionPolicy{Action: parsed, Allow: allow, Deny: deny}
+	if policy.allow, err = exceptionPatterns(allow); err != nil {
+		return ExceptionPolicy{}, err
+	}
+	if policy.deny, err = exceptionPatterns(deny); err != nil {
+		return ExceptionPolicy{}, err
+	}
+	return policy, nil
+}
+
+// DefaultExceptionPolicy logs every exception, pages carry on regardless
+// of most of them.
+func DefaultExceptionPolicy() ExceptionPolicy {
+	return ExceptionPolicy{Action: ExceptionActionLog}
+}
+
+func exceptionPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	output := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		compiled, err := WildcardPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exception pattern %s: %w", pattern, err)
+		}
+		output = append(output, compiled)
+	}
+	return output, nil
+}
+
+func matchesException(patterns []*regexp.Regexp, exception PageException) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(exception.Text) || pattern.MatchString(exception.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActionFor is what the policy does with exception.
+func (p ExceptionPolicy) ActionFor(exception PageException) ExceptionAction {
+	action := p.Action
+	if action == "" {
+		action = ExceptionActionLog
+	}
+	if matchesException(p.allow, exception) {
+		return ExceptionActionIgnore
+	}
+	if len(p.deny) > 0 && !matchesException(p.deny, exception) {
+		return ExceptionActionIgnore
+	}
+	return action
+}
+
+// ExceptionMonitor applies a policy to the exceptions thrown on a page.
+type ExceptionMonitor struct {
+	Policy ExceptionPolicy
+	// where exceptions are logged
+	log func(PageException)
+
+	mu       sync.Mutex
+	reported []PageException
+	// waiting to fail the next step
+	failures []PageException
+	cancel   context.CancelFunc
+}
+
+func NewExceptionMonitor(policy ExceptionPolicy, log func(PageException)) *ExceptionMonitor {
+	return &ExceptionMonitor{
+		Policy:   policy,
+		log:      log,
+		reported: []PageException{},
+		failures: []PageException{},
+	}
+}
+
+// WatchExceptions applies policy to the exceptions thrown in the browser
+// tab, until the returned monitor is stopped.
+func (a *Automation) WatchExceptions(policy ExceptionPolicy) *ExceptionMonitor {
+	monitor := NewExceptionMonitor(policy, func(exception PageException) {
+		a.Log.WithField("script", exception.URL).Warnf("Page threw: %s", exception.Text)
+	})
+	ctx, cancel := context.WithCancel(a.Context)
+	monitor.cancel = func() {
+		cancel()
+		if a.exceptions == monitor {
+			a.exceptions = nil
+		}
+	}
+	a.exceptions = monitor
+	chromedp.ListenTarget(ctx, monitor.HandleEvent)
+	return monitor
+}
+
+// HandleEvent applies the policy to exceptions, give it to chromedp.ListenTarget
+func (m *ExceptionMonitor) HandleEvent(v interface{}) {
+	ev, ok := v.(*runtime.EventExceptionThrown)
+	if !ok || ev.ExceptionDetails == nil {
+		return
+	}
+	m.Handle(NewPageException(ev.ExceptionDetails))
+}
+
+// Handle applies the policy to exception.
+func (m *ExceptionMonitor) Handle(exception PageException) {
+	action := m.Policy.ActionFor(exception)
+	if action == ExceptionActionIgnore {
+		return
+	}
+	if m.log != nil {
+		m.log(exception)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch action {
+	case ExceptionActionReport:
+		m.reported = append(m.reported, exception)
+	case ExceptionActionFail:
+		m.failures = append(m.failures, exception)
+	}
+}
+
+// Reported lists the exceptions to be shown in the run summary.
+func (m *ExceptionMonitor) Reported() []PageException {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]PageException{}, m.reported...)
+}
+
+// TakeFailure returns an error for the exceptions that should fail a step,
+// forgetting them so that only one step fails for each.
+func (m *ExceptionMonitor) TakeFailure() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.failures) == 0 {
+		return nil
+	}
+	exceptions := []string{}
+	for _, exception := range m.failures {
+		exceptions = append(exceptions, exception.String())
+	}
+	m.failures = []PageException{}
+	return fmt.Errorf("%w: %s", ErrPageException, strings.Join(exceptions, "; "))
+}
+
+// Stop stops applying the policy.
+func (m *ExceptionMonitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func thrown(description string, url string) *runtime.EventExceptionThrown {
+	return &runtime.EventExceptionThrown{
+		ExceptionDetails: &runtime.ExceptionDetails{
+			Text:         "Uncaught",
+			URL:          url,
+			LineNumber:   11,
+			ColumnNumber: 4,
+			Exception:    &runtime.RemoteObject{Description: description + "\n    at track (tracker.js:12:5)"},
+		},
+	}
+}
+
+func TestExceptionPolicyActionFor(t *testing.T) {
+	policy, err := NewExceptionPolicy("fail", []string{"*://*.tracker.example/*"}, []string{"TypeError*"})
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		exception PageException
+		expected  ExceptionAction
+	}{
+		{PageException{Text: "TypeError: x is undefined", URL: "https://bank.example/app.js"}, ExceptionActionFail},
+		{PageException{Text: "TypeError: x is undefined", URL: "https://cdn.tracker.example/t.js"}, ExceptionActionIgnore},
+		{PageException{Text: "ReferenceError: y is not defined", URL: "https://bank.example/app.js"}, ExceptionActionIgnore},
+	} {
+		assert.Equal(t, test.expected, policy.ActionFor(test.exception), test.exception.String())
+	}
+
+	_, err = NewExceptionPolicy("panic", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestExceptionMonitorReportsAndFails(t *testing.T) {
+	logged := []PageException{}
+	reporting, err := NewExceptionPolicy("report", nil, nil)
+	assert.NoError(t, err)
+	monitor := NewExceptionMonitor(reporting, func(exception PageException) {
+		logged = append(logged, exception)
+	})
+
+	monitor.HandleEvent(thrown("TypeError: x is undefined", "https://bank.example/app.js"))
+	assert.Len(t, logged, 1)
+	assert.Equal(t, "TypeError: x is undefined at https://bank.example/app.js:12:5", monitor.Reported()[0].String())
+	assert.NoError(t, monitor.TakeFailure())
+
+	failing, err := NewExceptionPolicy("fail", nil, nil)
+	assert.NoError(t, err)
+	monitor = NewExceptionMonitor(failing, nil)
+	monitor.HandleEvent(thrown("TypeError: x is undefined", "https://bank.example/app.js"))
+
+	err = monitor.TakeFailure()
+	assert.True(t, errors.Is(err, ErrPageException))
+	assert.Equal(t, "page-exception", ErrorClass(err))
+	// only one step fails for each exception
+	assert.NoError(t, monitor.TakeFailure())
+	assert.Empty(t, monitor.Reported())
+}
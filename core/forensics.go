@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// how much browser history is kept for a failure bundle
+const (
+	forensicsConsoleLimit = 500
+	forensicsNetworkLimit = 200
+)
+
+// NetworkRecord is a summary of a request the browser made.
+type NetworkRecord struct {
+	Started  time.Time `json:"started"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Type     string    `json:"type,omitempty"`
+	Status   int64     `json:"status,omitempty"`
+	MimeType string    `json:"mimeType,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Forensics remembers recent console output and network requests so that
+// a bundle describing the page can be written when an action fails.
+type Forensics struct {
+	Artifacts *RunArtifacts
+
+	mu       sync.Mutex
+	console  []string
+	requests map[network.RequestID]*NetworkRecord
+	order    []network.RequestID
+}
+
+func NewForensics(artifacts *RunArtifacts) *Forensics {
+	return &Forensics{
+		Artifacts: artifacts,
+		console:   []string{},
+		requests:  map[network.RequestID]*NetworkRecord{},
+		order:     []network.RequestID{},
+	}
+}
+
+// HandleEvent records console and network events, give it to chromedp.ListenTarget
+func (f *Forensics) HandleEvent(v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch ev := v.(type) {
+	case *runtime.EventConsoleAPICalled:
+		args := []string{}
+		for _, arg := range ev.Args {
+			args = append(args, describeRemoteObject(arg))
+		}
+		f.addConsole(fmt.Sprintf("[console.%s] %s", ev.Type, strings.Join(args, " ")))
+
+	case *log.EventEntryAdded:
+		f.addConsole(fmt.Sprintf("[%s] %s %s", ev.Entry.Level, ev.Entry.Text, ev.Entry.URL))
+
+	case *network.EventRequestWillBeSent:
+		f.addRequest(ev.RequestID, &NetworkRecord{
+			Started: time.Now(),
+			Method:  ev.Request.Method,
+			URL:     ev.Request.URL,
+			Type:    ev.Type.String(),
+		})
+
+	case *network.EventResponseReceived:
+		if record, ok := f.requests[ev.RequestID]; ok {
+			record.Status = ev.Response.Status
+			record.MimeType = ev.Response.MimeType
+		}
+
+	case *network.EventLoadingFailed:
+		if record, ok := f.requests[ev.RequestID]; ok {
+			record.Error = ev.ErrorText
+		}
+	}
+}
+
+func (f *Forensics) addConsole(line string) {
+	f.console = append(f.console, line)
+	if len(f.console) > forensicsConsoleLimit {
+		f.console = f.console[len(f.console)-forensicsConsoleLimit:]
+	}
+}
+
+func (f *Forensics) addRequest(id network.RequestID, record *NetworkRecord) {
+	// redirects reuse the request id
+	if _, ok := f.requests[id]; !ok {
+		f.order = append(f.order, id)
+	}
+	f.requests[id] = record
+
+	if len(f.order) > forensicsNetworkLimit {
+		delete(f.requests, f.order[0])
+		f.order = f.order[1:]
+	}
+}
+
+func describeRemoteObject(obj *runtime.RemoteObject) string {
+	if obj.Value != nil {
+		return UnQuote(string(obj.Value))
+	}
+	if obj.UnserializableValue != "" {
+		return string(obj.UnserializableValue)
+	}
+	return obj.Description
+}
+
+// Capture writes a screenshot, the DOM, console output and recent network
+// requests into a new directory of the run artifacts, returning its path.
+func (f *Forensics) Capture(ctx context.Context, step string) (string, error) {
+	name := fmt.Sprintf("%03d-%s", f.Artifacts.Next(), Slugify(step))
+	f.mu.Lock()
+	console := strings.Join(f.console, "\n")
+	requests := []NetworkRecord{}
+	for _, id := range f.order {
+		requests = append(requests, *f.requests[id])
+	}
+	f.mu.Unlock()
+
+	dir := filepath.Join("failures", name)
+	var errs []error
+
+	var screenshot []byte
+	if err := chromedp.Run(ctx, chromedp.FullScreenshot(&screenshot, 100)); err != nil {
+		errs = append(errs, fmt.Errorf("screenshot: %w", err))
+	} else if _, err := f.Artifacts.WriteFile(screenshot, dir, "screenshot.png"); err != nil {
+		errs = append(errs, err)
+	}
+
+	var html string
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		root, err := dom.GetDocument().Do(ctx)
+		if err != nil {
+			return err
+		}
+		html, err = dom.GetOuterHTML().WithNodeID(root.NodeID).Do(ctx)
+		return err
+	})); err != nil {
+		errs = append(errs, fmt.Errorf("dom: %w", err))
+	} else if _, err := f.Artifacts.WriteFile([]byte(html), dir, "dom.html"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := f.Artifacts.WriteFile([]byte(console), dir, "console.log"); err != nil {
+		errs = append(errs, err)
+	}
+
+	requestsJSON, err := json.MarshalIndent(requests, "", "  ")
+	if err == nil {
+		_, err = f.Artifacts.WriteFile(requestsJSON, dir, "network.json")
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return filepath.Join(f.Artifacts.Dir, dir), errors.Join(errs...)
+}
+
+// writes a failure bundle for the step, linking it from the log
+func (a *Automation) captureFailure(step string, err error) {
+	if a.forensics == nil {
+		return
+	}
+
+	// the run context may be the reason for the failure, so use the tab directly
+	ctx, cancel := context.WithTimeout(a.browserContext, 15*time.Second)
+	defer cancel()
+
+	dir, captureErr := a.forensics.Capture(ctx, step)
+	if captureErr != nil {
+		a.Log.Warnf("could not capture everything for %s: %s", step, captureErr)
+	}
+	a.Log.WithField("artifacts", dir).Errorf("%s failed: %s", step, err)
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForensicsRecordsNetworkRequests(t *testing.T) {
+	forensics := NewForensics(NewRunArtifacts(t.TempDir()))
+
+	forensics.HandleEvent(&network.EventRequestWillBeSent{
+		RequestID: "1",
+		Request:   &network.Request{Method: "GET", URL: "https://example.com/"},
+		Type:      network.ResourceTypeDocument,
+	})
+	forensics.HandleEvent(&network.EventResponseReceived{
+		RequestID: "1",
+		Response:  &network.Response{Status: 200, MimeType: "text/html"},
+	})
+
+	assert.Len(t, forensics.order, 1)
+	assert.Equal(t, int64(200), forensics.requests["1"].Status)
+	assert.Equal(t, "text/html", forensics.requests["1"].MimeType)
+}
+
+func TestForensicsKeepsRecentHistoryOnly(t *testing.T) {
+	forensics := NewForensics(NewRunArtifacts(t.TempDir()))
+
+	for i := 0; i < forensicsNetworkLimit+10; i++ {
+		forensics.HandleEvent(&network.EventRequestWillBeSent{
+			RequestID: network.RequestID(fmt.Sprint(i)),
+			Request:   &network.Request{Method: "GET", URL: "https://example.com/"},
+		})
+	}
+	for i := 0; i < forensicsConsoleLimit+10; i++ {
+		forensics.HandleEvent(&runtime.EventConsoleAPICalled{
+			Type: runtime.APITypeLog,
+			Args: []*runtime.RemoteObject{{Value: []byte(fmt.Sprintf(`"line %d"`, i))}},
+		})
+	}
+
+	assert.Len(t, forensics.order, forensicsNetworkLimit)
+	assert.Len(t, forensics.requests, forensicsNetworkLimit)
+	assert.Len(t, forensics.console, forensicsConsoleLimit)
+	assert.Equal(t, "[console.log] line 10", forensics.console[0])
+}
@@ -0,0 +1,369 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/airtonix/bank-downloaders/meta"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// what replaces credentials and cookies in a recording
+const harRedacted = "[REDACTED]"
+
+// headers whose values are never written to a recording
+var harSensitiveHeaders = []string{
+	"authorization",
+	"cookie",
+	"proxy-authorization",
+	"set-cookie",
+}
+
+// The subset of HAR 1.2 written by HarRecorder.
+// See: http://www.softwareishard.com/blog/har-12-spec/
+type Har struct {
+	Log HarLog `json:"log"`
+}
+
+type HarLog struct {
+	Version string     `json:"version"`
+	Creator HarCreator `json:"creator"`
+	Entries []HarEntry `json:"entries"`
+}
+
+type HarCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HarEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HarRequest  `json:"request"`
+	Response        HarResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HarTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HarRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HarNameValue `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	QueryString []HarNameValue `json:"queryString"`
+	PostData    *HarPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HarResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HarNameValue `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	Content     HarContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HarNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HarContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type HarTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// a request being recorded, until it finishes
+type harRecord struct {
+	entry   HarEntry
+	started time.Time
+}
+
+// HarRecorder turns the network events of a browser tab into a HAR file,
+// redacting the given secrets and every cookie along the way.
+type HarRecorder struct {
+	secrets []string
+
+	mu       sync.Mutex
+	records  map[network.RequestID]*harRecord
+	finished []HarEntry
+	cancel   context.CancelFunc
+}
+
+func NewHarRecorder(secrets ...string) *HarRecorder {
+	nonEmpty := []string{}
+	for _, secret := range secrets {
+		if secret != "" {
+			nonEmpty = append(nonEmpty, secret)
+		}
+	}
+	// replace the longest secrets first, so that a secret containing
+	// another is not left partially visible
+	sort.Slice(nonEmpty, func(i, j int) bool { return len(nonEmpty[i]) > len(nonEmpty[j]) })
+
+	return &HarRecorder{
+		secrets:  nonEmpty,
+		records:  map[network.RequestID]*harRecord{},
+		finished: []HarEntry{},
+	}
+}
+
+// RecordHar starts recording the network traffic of the browser tab,
+// until the returned recorder is stopped.
+func (a *Automation) RecordHar(secrets ...string) *HarRecorder {
+	recorder := NewHarRecorder(secrets...)
+	ctx, cancel := context.WithCancel(a.Context)
+	recorder.cancel = cancel
+	chromedp.ListenTarget(ctx, recorder.HandleEvent)
+	return recorder
+}
+
+// Stop stops listening for network events.
+func (h *HarRecorder) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
+
+// Redact replaces every secret in value, including url and json encoded
+// forms of it.
+func (h *HarRecorder) Redact(value string) string {
+	for _, secret := range h.secrets {
+		encoded, _ := json.Marshal(secret)
+		for _, form := range []string{
+			secret,
+			url.QueryEscape(secret),
+			url.PathEscape(secret),
+			UnQuote(string(encoded)),
+		} {
+			value = strings.ReplaceAll(value, form, harRedacted)
+		}
+	}
+	return value
+}
+
+func (h *HarRecorder) headers(headers network.Headers) []HarNameValue {
+	output := []HarNameValue{}
+	for _, name := range SortedKeys(headers) {
+		value := fmt.Sprint(headers[name])
+		for _, sensitive := range harSensitiveHeaders {
+			if strings.EqualFold(name, sensitive) {
+				value = harRedacted
+			}
+		}
+		output = append(output, HarNameValue{Name: name, Value: h.Redact(value)})
+	}
+	return output
+}
+
+// cookie names are kept, their values never are
+func (h *HarRecorder) cookies(headers network.Headers, name string) []HarNameValue {
+	output := []HarNameValue{}
+	for key, value := range headers {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		for _, line := range strings.Split(fmt.Sprint(value), "\n") {
+			for _, cookie := range strings.Split(line, ";") {
+				cookieName, _, found := strings.Cut(strings.TrimSpace(cookie), "=")
+				if !found || cookieName == "" {
+					continue
+				}
+				output = append(output, HarNameValue{Name: cookieName, Value: harRedacted})
+				// only the first pair of a set-cookie line is the cookie
+				if strings.EqualFold(name, "set-cookie") {
+					break
+				}
+			}
+		}
+	}
+	return output
+}
+
+func (h *HarRecorder) queryString(rawURL string) []HarNameValue {
+	output := []HarNameValue{}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return output
+	}
+	query := parsed.Query()
+	for _, name := range SortedKeys(query) {
+		for _, value := range query[name] {
+			output = append(output, HarNameValue{Name: name, Value: h.Redact(value)})
+		}
+	}
+	return output
+}
+
+// HandleEvent records network events, give it to chromedp.ListenTarget
+func (h *HarRecorder) HandleEvent(v interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch ev := v.(type) {
+	case *network.EventRequestWillBeSent:
+		started := time.Now()
+		if ev.WallTime != nil {
+			started = ev.WallTime.Time()
+		}
+
+		// a redirect finishes the previous request with the same id
+		if record, ok := h.records[ev.RequestID]; ok && ev.RedirectResponse != nil {
+			h.applyResponse(record, ev.RedirectResponse)
+			record.entry.Response.RedirectURL = h.Redact(ev.Request.URL)
+			h.finish(record, started)
+		}
+
+		request := HarRequest{
+			Method:      ev.Request.Method,
+			URL:         h.Redact(ev.Request.URL + ev.Request.URLFragment),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     h.cookies(ev.Request.Headers, "cookie"),
+			Headers:     h.headers(ev.Request.Headers),
+			QueryString: h.queryString(ev.Request.URL),
+			HeadersSize: -1,
+			BodySize:    int64(len(ev.Request.PostData)),
+		}
+		if ev.Request.HasPostData {
+			request.PostData = &HarPostData{
+				MimeType: headerValue(ev.Request.Headers, "content-type"),
+				Text:     h.Redact(ev.Request.PostData),
+			}
+		}
+
+		h.records[ev.RequestID] = &harRecord{
+			started: started,
+			entry: HarEntry{
+				StartedDateTime: started,
+				Request:         request,
+				Response: HarResponse{
+					Cookies: []HarNameValue{},
+					Headers: []HarNameValue{},
+				},
+			},
+		}
+
+	case *network.EventRequestWillBeSentExtraInfo:
+		// the headers actually sent, including cookies
+		if record, ok := h.records[ev.RequestID]; ok {
+			record.entry.Request.Headers = h.headers(ev.Headers)
+			record.entry.Request.Cookies = h.cookies(ev.Headers, "cookie")
+		}
+
+	case *network.EventResponseReceived:
+		if record, ok := h.records[ev.RequestID]; ok {
+			h.applyResponse(record, ev.Response)
+		}
+
+	case *network.EventResponseReceivedExtraInfo:
+		if record, ok := h.records[ev.RequestID]; ok {
+			record.entry.Response.Headers = h.headers(ev.Headers)
+			record.entry.Response.Cookies = h.cookies(ev.Headers, "set-cookie")
+		}
+
+	case *network.EventLoadingFinished:
+		if record, ok := h.records[ev.RequestID]; ok {
+			record.entry.Response.BodySize = int64(ev.EncodedDataLength)
+			h.finish(record, time.Now())
+			delete(h.records, ev.RequestID)
+		}
+
+	case *network.EventLoadingFailed:
+		if record, ok := h.records[ev.RequestID]; ok {
+			record.entry.Comment = ev.ErrorText
+			h.finish(record, time.Now())
+			delete(h.records, ev.RequestID)
+		}
+	}
+}
+
+func (h *HarRecorder) applyResponse(record *harRecord, response *network.Response) {
+	record.entry.Response.Status = response.Status
+	record.entry.Response.StatusText = response.StatusText
+	record.entry.Response.HTTPVersion = strings.ToUpper(response.Protocol)
+	record.entry.Response.Content = HarContent{
+		Size:     int64(response.EncodedDataLength),
+		MimeType: response.MimeType,
+	}
+	record.entry.Response.HeadersSize = -1
+	if len(record.entry.Response.Headers) == 0 {
+		record.entry.Response.Headers = h.headers(response.Headers)
+		record.entry.Response.Cookies = h.cookies(response.Headers, "set-cookie")
+	}
+	if response.Protocol != "" {
+		record.entry.Request.HTTPVersion = strings.ToUpper(response.Protocol)
+	}
+}
+
+func headerValue(headers network.Headers, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// completes an entry, copying it into the finished list
+func (h *HarRecorder) finish(record *harRecord, finished time.Time) {
+	elapsed := float64(finished.Sub(record.started)) / float64(time.Millisecond)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	record.entry.Time = elapsed
+	record.entry.Timings = HarTimings{Wait: elapsed}
+	h.finished = append(h.finished, record.entry)
+}
+
+// Save writes every finished request to path as a HAR file.
+func (h *HarRecorder) Save(path string) error {
+	h.mu.Lock()
+	entries := append([]HarEntry{}, h.finished...)
+	h.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+
+	content, err := json.MarshalIndent(Har{
+		Log: HarLog{
+			Version: "1.2",
+			Creator: HarCreator{
+				Name:    meta.Name,
+				Version: meta.Version,
+			},
+			Entries: entries,
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0640)
+}
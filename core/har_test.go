@@ -0,0 +1,63 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHarRecorderRedactsCredentialsAndCookies(t *testing.T) {
+	recorder := NewHarRecorder("someguy", "p@ss word", "")
+
+	recorder.HandleEvent(&network.EventRequestWillBeSent{
+		RequestID: "1",
+		Request: &network.Request{
+			Method: "POST",
+			URL:    "https://bank.example/login?user=someguy",
+			Headers: network.Headers{
+				"Content-Type": "application/json",
+				"Cookie":       "session=abc123; theme=dark",
+			},
+			HasPostData: true,
+			PostData:    `{"username":"someguy","password":"p@ss word"}`,
+		},
+	})
+	recorder.HandleEvent(&network.EventResponseReceived{
+		RequestID: "1",
+		Response: &network.Response{
+			Status:   200,
+			MimeType: "application/json",
+			Headers: network.Headers{
+				"Set-Cookie": "session=def456; Path=/; HttpOnly",
+			},
+		},
+	})
+	recorder.HandleEvent(&network.EventLoadingFinished{RequestID: "1"})
+
+	path := filepath.Join(t.TempDir(), "session.har")
+	assert.NoError(t, recorder.Save(path))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	for _, secret := range []string{"someguy", "p@ss word", "p%40ss+word", "abc123", "def456"} {
+		assert.False(t, strings.Contains(string(content), secret), "leaked %s", secret)
+	}
+
+	var har Har
+	assert.NoError(t, json.Unmarshal(content, &har))
+	assert.Len(t, har.Log.Entries, 1)
+
+	entry := har.Log.Entries[0]
+	assert.Equal(t, "https://bank.example/login?user=[REDACTED]", entry.Request.URL)
+	assert.Equal(t, []HarNameValue{
+		{Name: "session", Value: harRedacted},
+		{Name: "theme", Value: harRedacted},
+	}, entry.Request.Cookies)
+	assert.Equal(t, []HarNameValue{{Name: "session", Value: harRedacted}}, entry.Response.Cookies)
+	assert.Equal(t, int64(200), entry.Response.Status)
+}
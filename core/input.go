@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// InputMode is how Fill puts a value into a field.
+type InputMode string
+
+const (
+	// sets the value property directly, fast but skips key and input
+	// events so some script driven fields never notice
+	InputModeSetValue InputMode = "set-value"
+	// types the value one key event at a time, like a person would
+	InputModeKeystrokes InputMode = "keystrokes"
+)
+
+func ParseInputMode(mode string) (InputMode, error) {
+	switch InputMode(mode) {
+	case "":
+		return InputModeSetValue, nil
+	case InputModeSetValue, InputModeKeystrokes:
+		return InputMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown input mode: %s, expected %s or %s", mode, InputModeSetValue, InputModeKeystrokes)
+}
+
+// InputOptions describes how values are filled into fields.
+type InputOptions struct {
+	// used for every field not listed in Keystrokes
+	Mode InputMode
+	// selectors that are always typed into
+	Keystrokes []string
+	// wait between key events when typing
+	KeyDelay time.Duration
+	// up to this much random extra wait is added to each key
+	Jitter time.Duration
+}
+
+func DefaultInputOptions() InputOptions {
+	return InputOptions{
+		Mode:     InputModeSetValue,
+		KeyDelay: 20 * time.Millisecond,
+	}
+}
+
+// UseKeystrokes types into the selectors whatever the input mode, for
+// fields that ignore their value being set directly.
+func (a *Automation) UseKeystrokes(selectors ...string) {
+	if a.keystrokeSelectors == nil {
+		a.keystrokeSelectors = map[string]bool{}
+	}
+	for _, selector := range selectors {
+		a.keystrokeSelectors[selector] = true
+	}
+}
+
+// the input mode used for selector
+func (a *Automation) inputModeFor(selector string) InputMode {
+	if a.keystrokeSelectors[selector] {
+		return InputModeKeystrokes
+	}
+	for _, keystrokes := range a.Options.Input.Keystrokes {
+		if keystrokes == selector {
+			return InputModeKeystrokes
+		}
+	}
+	if a.Options.Input.Mode == "" {
+		return InputModeSetValue
+	}
+	return a.Options.Input.Mode
+}
+
+// how long to wait after a key event
+func (a *Automation) keyDelay() time.Duration {
+	delay := a.Options.Input.KeyDelay
+	if a.Options.Input.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(a.Options.Input.Jitter)))
+	}
+	return delay
+}
+
+// the actions that replace the value of selector, found with query
+func (a *Automation) inputActions(selector string, query interface{}, options []chromedp.QueryOption, value string) []chromedp.Action {
+	if a.inputModeFor(selector) != InputModeKeystrokes {
+		return []chromedp.Action{
+			chromedp.SetValue(query, value, options...),
+		}
+	}
+
+	return []chromedp.Action{
+		chromedp.Focus(query, options...),
+		// clear whatever is already there
+		chromedp.KeyEvent("a", chromedp.KeyModifiers(input.ModifierCtrl)),
+		chromedp.KeyEvent(kb.Backspace),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			for _, key := range value {
+				if err := chromedp.KeyEvent(string(key)).Do(ctx); err != nil {
+					return err
+				}
+				if err := chromedp.Sleep(a.keyDelay()).Do(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputModeForSelector(t *testing.T) {
+	automation := &Automation{
+		Options: NewAutomationOptions(WithInput(InputOptions{
+			Mode:       InputModeSetValue,
+			Keystrokes: []string{"#configured"},
+		})),
+	}
+	automation.UseKeystrokes("#declared")
+
+	assert.Equal(t, InputModeSetValue, automation.inputModeFor("#other"))
+	assert.Equal(t, InputModeKeystrokes, automation.inputModeFor("#configured"))
+	assert.Equal(t, InputModeKeystrokes, automation.inputModeFor("#declared"))
+
+	automation.Options.Input.Mode = InputModeKeystrokes
+	assert.Equal(t, InputModeKeystrokes, automation.inputModeFor("#other"))
+
+	_, err := ParseInputMode("telepathy")
+	assert.ErrorContains(t, err, "unknown input mode: telepathy")
+}
+
+func TestKeyDelayStaysWithinJitter(t *testing.T) {
+	automation := &Automation{
+		Options: NewAutomationOptions(WithInput(InputOptions{
+			KeyDelay: 10 * time.Millisecond,
+			Jitter:   5 * time.Millisecond,
+		})),
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := automation.keyDelay()
+		assert.GreaterOrEqual(t, delay, 10*time.Millisecond)
+		assert.Less(t, delay, 15*time.Millisecond)
+	}
+}
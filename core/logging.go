@@ -7,10 +7,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// AssertErrorToNilf logs the error, formatted into message, and reports
+// whether there was one so the caller can decide how to carry on.
 func AssertErrorToNilf(message string, err error) bool {
 	if err != nil {
-		logrus.Panic(
-			color.FgRed.Render(fmt.Sprintf(message, err)),
+		logrus.Error(
+			color.FgRed.Render(fmt.Errorf(message, err).Error()),
 		)
 		return true
 	}
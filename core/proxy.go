@@ -0,0 +1,646 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyOptions is the proxy a browser context sends its traffic through.
+type ProxyOptions struct {
+	// eg: http://proxy.example:3128 or socks5://127.0.0.1:1080
+	URL      string
+	Username string
+	Password string
+	// hosts reached directly, * matches anything, eg: *.internal.example
+	Bypass []string
+}
+
+// ClientCertificate is presented to the hosts that ask for one, for banks
+// that require mutual tls.
+type ClientCertificate struct {
+	// host patterns the certificate is presented to, * matches anything
+	Hosts []string
+	// pem encoded certificate chain and private key
+	CertFile string
+	KeyFile  string
+	// pem encoded authorities trusted for those hosts, besides the system ones
+	CAFile string
+}
+
+var proxySchemes = []string{"http", "https", "socks5"}
+
+// NewProxyOptions checks the proxy url is one the browser can use.
+func NewProxyOptions(proxyURL string, username string, password string, bypass []string) (*ProxyOptions, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %s: %w", proxyURL, err)
+	}
+	known := false
+	for _, scheme := range proxySchemes {
+		known = known || parsed.Scheme == scheme
+	}
+	if !known || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url: %s, expected <%s>://host:port", proxyURL, strings.Join(proxySchemes, "|"))
+	}
+	for _, pattern := range bypass {
+		if _, err := WildcardPattern(pattern); err != nil {
+			return nil, fmt.Errorf("invalid proxy bypass %s: %w", pattern, err)
+		}
+	}
+	return &ProxyOptions{
+		URL:      proxyURL,
+		Username: username,
+		Password: password,
+		Bypass:   bypass,
+	}, nil
+}
+
+// NeedsLocalProxy reports whether the browser can't use the proxy by itself,
+// since it has no way to be given proxy credentials or client certificates.
+func NeedsLocalProxy(upstream *ProxyOptions, certificates []ClientCertificate) bool {
+	return len(certificates) > 0 || (upstream != nil && upstream.Username != "")
+}
+
+// the proxy an automation's browser context is created with, along with the
+// local proxy standing in for the upstream one when the browser needs help
+func contextProxy(o AutomationOptions) (chromedp.CreateBrowserContextOption, *LocalProxy, error) {
+	if o.Proxy == nil && len(o.ClientCertificates) == 0 {
+		return nil, nil, nil
+	}
+
+	if !NeedsLocalProxy(o.Proxy, o.ClientCertificates) {
+		return func(p *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+			return p.WithProxyServer(o.Proxy.URL).WithProxyBypassList(strings.Join(o.Proxy.Bypass, ","))
+		}, nil, nil
+	}
+
+	if o.IsRemote() {
+		return nil, nil, fmt.Errorf("proxy credentials and client certificates need a browser on this machine, not %s", o.RemoteURL)
+	}
+	local, err := NewLocalProxy(o.Proxy, o.ClientCertificates)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := local.Start(); err != nil {
+		return nil, nil, err
+	}
+	return func(p *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+		// bypassed hosts are dialed directly by the local proxy
+		return p.WithProxyServer(local.URL())
+	}, local, nil
+}
+
+// a client certificate ready to be presented
+type hostCertificate struct {
+	hosts     []*regexp.Regexp
+	transport *http.Transport
+}
+
+// LocalProxy is a proxy on this machine that does what the browser can't,
+// logging into an upstream proxy and presenting client certificates.
+//
+// When client certificates are presented, every https connection is
+// terminated here with a certificate of its own, so the browser must be
+// told to trust it. The real certificates of the hosts are checked here.
+type LocalProxy struct {
+	// where the browser connects to, eg: 127.0.0.1:52811
+	Addr string
+	// the login only the browser is given, so that nothing else on this
+	// machine can reach the bank through the proxy
+	Username string
+	Password string
+
+	upstream     *ProxyOptions
+	bypass       []*regexp.Regexp
+	certificates []hostCertificate
+	// used for hosts without a client certificate
+	transport *http.Transport
+	dialer    proxy.ContextDialer
+
+	// signs the certificates shown to the browser
+	ca      *x509.Certificate
+	caKey   *ecdsa.PrivateKey
+	leafKey *ecdsa.PrivateKey
+	mu      sync.Mutex
+	leaves  map[string]*tls.Certificate
+
+	listener net.Listener
+	server   *http.Server
+}
+
+func NewLocalProxy(upstream *ProxyOptions, certificates []ClientCertificate) (*LocalProxy, error) {
+	p := &LocalProxy{
+		upstream: upstream,
+		bypass:   []*regexp.Regexp{},
+		leaves:   map[string]*tls.Certificate{},
+	}
+
+	dialer, err := upstreamDialer(upstream)
+	if err != nil {
+		return nil, err
+	}
+	p.dialer = dialer
+	if upstream != nil {
+		for _, pattern := range upstream.Bypass {
+			compiled, err := WildcardPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			p.bypass = append(p.bypass, compiled)
+		}
+	}
+
+	p.Username, err = randomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	p.Password, err = randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	p.transport = p.newTransport(nil)
+	for _, certificate := range certificates {
+		loaded, err := p.loadCertificate(certificate)
+		if err != nil {
+			return nil, err
+		}
+		p.certificates = append(p.certificates, loaded)
+	}
+
+	if len(p.certificates) > 0 {
+		if err := p.createAuthority(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func randomToken(size int) (string, error) {
+	token := make([]byte, size)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("could not create local proxy login: %w", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// dials hosts through the upstream proxy, or directly without one
+func upstreamDialer(upstream *ProxyOptions) (proxy.ContextDialer, error) {
+	direct := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if upstream == nil {
+		return direct, nil
+	}
+
+	parsed, err := url.Parse(upstream.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if upstream.Username != "" {
+			auth = &proxy.Auth{User: upstream.Username, Password: upstream.Password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", parsed.Host, auth, direct)
+		if err != nil {
+			return nil, fmt.Errorf("could not use proxy %s: %w", upstream.URL, err)
+		}
+		return dialer.(proxy.ContextDialer), nil
+	default:
+		return &connectDialer{proxy: parsed, upstream: upstream, direct: direct}, nil
+	}
+}
+
+// dials hosts by asking an http proxy to CONNECT to them
+type connectDialer struct {
+	proxy    *url.URL
+	upstream *ProxyOptions
+	direct   *net.Dialer
+}
+
+func (d *connectDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	conn, err := d.direct.DialContext(ctx, network, d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	if d.proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.upstream.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.upstream.Username + ":" + d.upstream.Password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", d.proxy.Host, addr, response.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// a connection whose first bytes were already read into reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// dial reaches addr through the upstream proxy, unless it is bypassed
+func (p *LocalProxy) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	for _, pattern := range p.bypass {
+		if pattern.MatchString(host) {
+			return (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, network, addr)
+		}
+	}
+	return p.dialer.DialContext(ctx, network, addr)
+}
+
+func (p *LocalProxy) newTransport(config *tls.Config) *http.Transport {
+	return &http.Transport{
+		DialContext:         p.dial,
+		TLSClientConfig:     config,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+func (p *LocalProxy) loadCertificate(certificate ClientCertificate) (hostCertificate, error) {
+	loaded := hostCertificate{hosts: []*regexp.Regexp{}}
+	if len(certificate.Hosts) == 0 {
+		return loaded, fmt.Errorf("client certificate %s has no hosts", certificate.CertFile)
+	}
+	for _, host := range certificate.Hosts {
+		pattern, err := WildcardPattern(host)
+		if err != nil {
+			return loaded, fmt.Errorf("invalid client certificate host %s: %w", host, err)
+		}
+		loaded.hosts = append(loaded.hosts, pattern)
+	}
+
+	pair, err := tls.LoadX509KeyPair(certificate.CertFile, certificate.KeyFile)
+	if err != nil {
+		return loaded, fmt.Errorf("could not load client certificate %s: %w", certificate.CertFile, err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{pair}}
+
+	if certificate.CAFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		content, err := os.ReadFile(certificate.CAFile)
+		if err != nil {
+			return loaded, fmt.Errorf("could not load certificate authority %s: %w", certificate.CAFile, err)
+		}
+		if !roots.AppendCertsFromPEM(content) {
+			return loaded, fmt.Errorf("no certificates found in %s", certificate.CAFile)
+		}
+		config.RootCAs = roots
+	}
+
+	loaded.transport = p.newTransport(config)
+	return loaded, nil
+}
+
+// the transport for requests to host, presenting its client certificate
+func (p *LocalProxy) transportFor(host string) *http.Transport {
+	for _, certificate := range p.certificates {
+		for _, pattern := range certificate.hosts {
+			if pattern.MatchString(host) {
+				return certificate.transport
+			}
+		}
+	}
+	return p.transport
+}
+
+// makes the authority that signs what the browser is shown, it only lives
+// as long as the proxy
+func (p *LocalProxy) createAuthority() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bank-downloader local proxy"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	p.ca, err = x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	p.caKey = key
+
+	p.leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return err
+}
+
+// the certificate shown to the browser for host
+func (p *LocalProxy) leafFor(host string) (*tls.Certificate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if leaf, ok := p.leaves[host]; ok {
+		return leaf, nil
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &p.leafKey.PublicKey, p.caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf := &tls.Certificate{
+		Certificate: [][]byte{der, p.ca.Raw},
+		PrivateKey:  p.leafKey,
+	}
+	p.leaves[host] = leaf
+	return leaf, nil
+}
+
+// Intercepts reports whether https connections are terminated here, and
+// so whether the browser has to accept certificates it doesn't know.
+func (p *LocalProxy) Intercepts() bool {
+	return len(p.certificates) > 0
+}
+
+// Start listens for the browser on a free local port.
+func (p *LocalProxy) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("could not start local proxy: %w", err)
+	}
+	p.listener = listener
+	p.Addr = listener.Addr().String()
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(listener)
+	return nil
+}
+
+// URL is what the browser is given as its proxy server.
+func (p *LocalProxy) URL() string {
+	return "http://" + p.Addr
+}
+
+func (p *LocalProxy) Close() error {
+	if p.server == nil {
+		return nil
+	}
+	p.transport.CloseIdleConnections()
+	for _, certificate := range p.certificates {
+		certificate.transport.CloseIdleConnections()
+	}
+	return p.server.Close()
+}
+
+// authorized reports whether the request carries the proxy's login
+func (p *LocalProxy) authorized(r *http.Request) bool {
+	scheme, credentials, ok := strings.Cut(r.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return false
+	}
+	expected := []byte(p.Username + ":" + p.Password)
+	return subtle.ConstantTimeCompare(decoded, expected) == 1
+}
+
+func (p *LocalProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="bank-downloader"`)
+		http.Error(w, "proxy login required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	if r.Method != http.MethodConnect {
+		// a plain http request, with an absolute url
+		p.reverseProxy("").ServeHTTP(w, r)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can not hijack connection", http.StatusInternalServerError)
+		return
+	}
+
+	var upstream net.Conn
+	if !p.Intercepts() {
+		var err error
+		upstream, err = p.dial(r.Context(), "tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		if upstream != nil {
+			upstream.Close()
+		}
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+
+	if upstream != nil {
+		tunnel(conn, upstream)
+		return
+	}
+	p.intercept(conn, r.Host)
+}
+
+// copies between the two connections until either closes
+func tunnel(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copy := func(dst net.Conn, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copy(a, b)
+	go copy(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// terminates the browser's tls connection to host, forwarding its requests
+func (p *LocalProxy) intercept(conn net.Conn, host string) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return p.leafFor(hello.ServerName)
+			}
+			return p.leafFor(hostname)
+		},
+	})
+
+	listener := newConnListener(tlsConn)
+	server := &http.Server{Handler: p.reverseProxy(host)}
+	server.Serve(listener)
+}
+
+// forwards requests, to host over https when given one
+func (p *LocalProxy) reverseProxy(host string) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			if host != "" {
+				r.URL.Scheme = "https"
+				r.URL.Host = host
+			}
+			// let go set its own
+			if _, ok := r.Header["User-Agent"]; !ok {
+				r.Header.Set("User-Agent", "")
+			}
+		},
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return p.transportFor(r.URL.Hostname()).RoundTrip(r)
+		}),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// a listener that accepts a single connection, closing once it is
+type connListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, closed: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() {
+		conn = &closeNotifyConn{Conn: l.conn, closed: l.closed}
+	})
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// tells the listener when the connection it handed out is done
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.closed) })
+	return err
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProxyOptions(t *testing.T) {
+	_, err := NewProxyOptions("socks5://127.0.0.1:1080", "", "", []string{"*.internal.example"})
+	assert.NoError(t, err)
+
+	_, err = NewProxyOptions("ftp://proxy.example:21", "", "", nil)
+	assert.Error(t, err)
+
+	_, err = NewProxyOptions("proxy.example:3128", "", "", nil)
+	assert.Error(t, err)
+}
+
+func TestNeedsLocalProxy(t *testing.T) {
+	assert.False(t, NeedsLocalProxy(nil, nil))
+	assert.False(t, NeedsLocalProxy(&ProxyOptions{URL: "http://proxy.example:3128"}, nil))
+	assert.True(t, NeedsLocalProxy(&ProxyOptions{URL: "http://proxy.example:3128", Username: "bob"}, nil))
+	assert.True(t, NeedsLocalProxy(nil, []ClientCertificate{{Hosts: []string{"*"}}}))
+}
+
+// a client that sends everything through the local proxy, logging into it
+// and trusting whatever it is shown like the browser is told to
+func proxiedClient(local *LocalProxy) *http.Client {
+	proxyURL, _ := url.Parse(local.URL())
+	proxyURL.User = url.UserPassword(local.Username, local.Password)
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+func TestLocalProxyLogsIntoUpstream(t *testing.T) {
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "statement")
+	}))
+	defer bank.Close()
+
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("bob:hunter2"))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != expected {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		conn, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		client, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(client, "HTTP/1.1 200 OK\r\n\r\n")
+		tunnel(client, conn)
+	}))
+	defer upstream.Close()
+
+	options, err := NewProxyOptions(upstream.URL, "bob", "hunter2", nil)
+	assert.NoError(t, err)
+	local, err := NewLocalProxy(options, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, local.Start())
+	defer local.Close()
+
+	response, err := proxiedClient(local).Get(bank.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, "statement", string(body))
+
+	options.Password = "wrong"
+	refused, err := NewLocalProxy(options, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, refused.Start())
+	defer refused.Close()
+
+	response, err = proxiedClient(refused).Get(bank.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, response.StatusCode)
+}
+
+func TestLocalProxyRequiresItsLogin(t *testing.T) {
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+		io.WriteString(w, "statement")
+	}))
+	defer bank.Close()
+
+	local, err := NewLocalProxy(nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, local.Start())
+	defer local.Close()
+
+	other, err := NewLocalProxy(nil, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, local.Password, other.Password)
+
+	proxyURL, _ := url.Parse(local.URL())
+	for _, login := range []*url.Userinfo{nil, url.UserPassword(local.Username, "wrong")} {
+		proxyURL.User = login
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		response, err := client.Get(bank.URL)
+		if !assert.NoError(t, err) {
+			return
+		}
+		response.Body.Close()
+		assert.Equal(t, http.StatusProxyAuthRequired, response.StatusCode)
+		assert.Contains(t, response.Header.Get("Proxy-Authenticate"), "Basic")
+	}
+
+	response, err := proxiedClient(local).Get(bank.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, "statement", string(body))
+}
+
+// writes a certificate and key signed by the returned authority
+func writeClientCertificate(t *testing.T, dir string) (*x509.CertPool, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "customer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return pool, certFile, keyFile
+}
+
+func TestLocalProxyPresentsClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	clients, certFile, keyFile := writeClientCertificate(t, dir)
+
+	bank := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	bank.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	bank.StartTLS()
+	defer bank.Close()
+
+	// the bank's own certificate is only trusted through the ca file
+	caFile := filepath.Join(dir, "bank-ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: bank.Certificate().Raw}), 0600))
+
+	local, err := NewLocalProxy(nil, []ClientCertificate{{
+		Hosts:    []string{"127.0.0.1"},
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   caFile,
+	}})
+	assert.NoError(t, err)
+	assert.True(t, local.Intercepts())
+	assert.NoError(t, local.Start())
+	defer local.Close()
+
+	response, err := proxiedClient(local).Get(bank.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "customer", string(body))
+}
+
+func TestLocalProxyChecksRealCertificates(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeClientCertificate(t, dir)
+
+	bank := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "statement")
+	}))
+	defer bank.Close()
+
+	// without the ca file the bank's test certificate is not trusted
+	local, err := NewLocalProxy(nil, []ClientCertificate{{
+		Hosts:    []string{"bank.example"},
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}})
+	assert.NoError(t, err)
+	assert.NoError(t, local.Start())
+	defer local.Close()
+
+	response, err := proxiedClient(local).Get(bank.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, response.StatusCode)
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RunFailure records an account that could not be downloaded.
+type RunFailure struct {
+	Source  string
+	Account string
+	Err     error
+}
+
+// RunException records an exception a page threw while downloading a source.
+type RunException struct {
+	Source    string
+	Exception PageException
+}
+
+// RunReport collects the outcome of every account processed in a run,
+// it is safe to add to from sources downloading side by side.
+type RunReport struct {
+	Succeeded  []string
+	Failures   []RunFailure
+	Exceptions []RunException
+
+	mu sync.Mutex
+}
+
+func NewRunReport() *RunReport {
+	return &RunReport{
+		Succeeded:  []string{},
+		Failures:   []RunFailure{},
+		Exceptions: []RunException{},
+	}
+}
+
+func (r *RunReport) AddSuccess(source string, account string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Succeeded = append(r.Succeeded, fmt.Sprintf("%s: %s", source, account))
+}
+
+func (r *RunReport) AddFailure(source string, account string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failures = append(r.Failures, RunFailure{
+		Source:  source,
+		Account: account,
+		Err:     err,
+	})
+}
+
+func (r *RunReport) AddException(source string, exception PageException) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Exceptions = append(r.Exceptions, RunException{
+		Source:    source,
+		Exception: exception,
+	})
+}
+
+func (r *RunReport) HasFailures() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Failures) > 0
+}
+
+// Print writes a summary of the run to stdout
+func (r *RunReport) Print() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	Header("Summary")
+	KeyValue("succeeded", len(r.Succeeded))
+	KeyValue("failed", len(r.Failures))
+
+	if len(r.Exceptions) > 0 {
+		Header("Page Exceptions")
+		for _, exception := range r.Exceptions {
+			KeyValue(exception.Source, exception.Exception)
+		}
+	}
+
+	if len(r.Failures) == 0 {
+		return
+	}
+
+	Header("Failures")
+	for _, failure := range r.Failures {
+		KeyValue(
+			fmt.Sprintf("%s: %s", failure.Source, failure.Account),
+			failure.Err,
+		)
+	}
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// WildcardPattern turns a url pattern where * matches anything into a
+// regular expression matching whole urls.
+func WildcardPattern(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// CapturedResponse is a response the browser received, with its body.
+type CapturedResponse struct {
+	Received time.Time `json:"received"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Status   int64     `json:"status"`
+	MimeType string    `json:"mimeType"`
+	Body     []byte    `json:"-"`
+}
+
+// JSON decodes the body into v.
+func (r CapturedResponse) JSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// MarshalJSON writes json bodies as they are, anything else as a string.
+func (r CapturedResponse) MarshalJSON() ([]byte, error) {
+	type response CapturedResponse
+	var body interface{} = string(r.Body)
+	if json.Valid(r.Body) {
+		body = json.RawMessage(r.Body)
+	}
+	return json.Marshal(struct {
+		response
+		Body interface{} `json:"body"`
+	}{response(r), body})
+}
+
+// ResponseCapture collects the bodies of responses whose url matches a
+// pattern, until it is stopped.
+type ResponseCapture struct {
+	pattern *regexp.Regexp
+	// reads the body of a finished response from the browser
+	fetch func(network.RequestID) ([]byte, error)
+
+	mu        sync.Mutex
+	pending   map[network.RequestID]*CapturedResponse
+	responses []CapturedResponse
+	errs      []error
+	stopped   bool
+	fetches   sync.WaitGroup
+	cancel    context.CancelFunc
+}
+
+func NewResponseCapture(urlPattern string, fetch func(network.RequestID) ([]byte, error)) (*ResponseCapture, error) {
+	pattern, err := WildcardPattern(urlPattern)
+	if err != nil {
+		return nil, fmt.Errorf("could not capture responses: %w", err)
+	}
+	return &ResponseCapture{
+		pattern:   pattern,
+		fetch:     fetch,
+		pending:   map[network.RequestID]*CapturedResponse{},
+		responses: []CapturedResponse{},
+	}, nil
+}
+
+// CaptureResponses starts collecting the bodies of responses whose url
+// matches urlPattern, where * matches anything. Stop it to get them.
+func (a *Automation) CaptureResponses(urlPattern string) (*ResponseCapture, error) {
+	ctx, cancel := context.WithCancel(a.Context)
+	capture, err := NewResponseCapture(urlPattern, func(id network.RequestID) ([]byte, error) {
+		var body []byte
+		err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			body, err = network.GetResponseBody(id).Do(ctx)
+			return err
+		}))
+		return body, err
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	capture.cancel = cancel
+
+	a.Log.Debugf("Capturing responses from %s", urlPattern)
+	chromedp.ListenTarget(ctx, capture.HandleEvent)
+	return capture, nil
+}
+
+// HandleEvent follows matching responses, give it to chromedp.ListenTarget
+func (c *ResponseCapture) HandleEvent(v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+
+	switch ev := v.(type) {
+	case *network.EventRequestWillBeSent:
+		if c.pattern.MatchString(ev.Request.URL) {
+			c.pending[ev.RequestID] = &CapturedResponse{
+				Method: ev.Request.Method,
+				URL:    ev.Request.URL,
+			}
+		}
+
+	case *network.EventResponseReceived:
+		if response, ok := c.pending[ev.RequestID]; ok {
+			response.Status = ev.Response.Status
+			response.MimeType = ev.Response.MimeType
+		}
+
+	case *network.EventLoadingFinished:
+		response, ok := c.pending[ev.RequestID]
+		if !ok {
+			return
+		}
+		delete(c.pending, ev.RequestID)
+
+		// listeners must not block, so the body is read elsewhere
+		c.fetches.Add(1)
+		go func(id network.RequestID, response CapturedResponse) {
+			defer c.fetches.Done()
+			body, err := c.fetch(id)
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if err != nil {
+				c.errs = append(c.errs, fmt.Errorf("could not read response of %s: %w", response.URL, err))
+				return
+			}
+			response.Received = time.Now()
+			response.Body = body
+			c.responses = append(c.responses, response)
+		}(ev.RequestID, *response)
+
+	case *network.EventLoadingFailed:
+		delete(c.pending, ev.RequestID)
+	}
+}
+
+// Stop stops capturing, waiting for bodies still being read. Returns the
+// captured responses, along with any that could not be read.
+func (c *ResponseCapture) Stop() ([]CapturedResponse, error) {
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+
+	c.fetches.Wait()
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CapturedResponse{}, c.responses...), errors.Join(c.errs...)
+}
+
+// SaveResponses writes responses to path as a json array.
+func SaveResponses(path string, responses []CapturedResponse) error {
+	content, err := json.MarshalIndent(responses, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0640)
+}
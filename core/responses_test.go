@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCaptureCollectsMatchingBodies(t *testing.T) {
+	bodies := map[network.RequestID][]byte{
+		"1": []byte(`{"transactions":[{"amount":-4.5,"category":"cafe","pending":true}]}`),
+	}
+	capture, err := NewResponseCapture("https://bank.example/api/*/transactions*", func(id network.RequestID) ([]byte, error) {
+		if body, ok := bodies[id]; ok {
+			return body, nil
+		}
+		return nil, errors.New("no resource with given identifier found")
+	})
+	assert.NoError(t, err)
+
+	for id, url := range map[network.RequestID]string{
+		"1": "https://bank.example/api/v2/transactions?account=123",
+		"2": "https://bank.example/api/v2/accounts",
+		"3": "https://bank.example/api/v1/transactions",
+	} {
+		capture.HandleEvent(&network.EventRequestWillBeSent{
+			RequestID: id,
+			Request:   &network.Request{Method: "GET", URL: url},
+		})
+		capture.HandleEvent(&network.EventResponseReceived{
+			RequestID: id,
+			Response:  &network.Response{Status: 200, MimeType: "application/json"},
+		})
+		capture.HandleEvent(&network.EventLoadingFinished{RequestID: id})
+	}
+
+	responses, err := capture.Stop()
+	assert.ErrorContains(t, err, "could not read response of https://bank.example/api/v1/transactions")
+	assert.Len(t, responses, 1)
+	assert.Equal(t, "https://bank.example/api/v2/transactions?account=123", responses[0].URL)
+
+	var payload struct {
+		Transactions []struct {
+			Category string
+			Pending  bool
+		}
+	}
+	assert.NoError(t, responses[0].JSON(&payload))
+	assert.Equal(t, "cafe", payload.Transactions[0].Category)
+	assert.True(t, payload.Transactions[0].Pending)
+
+	// nothing more is captured once stopped
+	capture.HandleEvent(&network.EventRequestWillBeSent{
+		RequestID: "4",
+		Request:   &network.Request{Method: "GET", URL: "https://bank.example/api/v2/transactions"},
+	})
+	assert.Empty(t, capture.pending)
+
+	path := filepath.Join(t.TempDir(), "statement.responses.json")
+	assert.NoError(t, SaveResponses(path, responses))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var saved []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(content, &saved))
+	assert.Equal(t, "cafe", saved[0]["body"].(map[string]interface{})["transactions"].([]interface{})[0].(map[string]interface{})["category"])
+}
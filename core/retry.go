@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorClasses names the kinds of failure a retry policy can retry.
+var ErrorClasses = map[string]error{
+	"selector-not-found": ErrSelectorNotFound,
+	"node-detached":      ErrNodeDetached,
+	"navigation-failed":  ErrNavigationFailed,
+	"download-timed-out": ErrDownloadTimedOut,
+	"download-failed":    ErrDownloadFailed,
+	"deadline-exceeded":  ErrDeadlineExceeded,
+	"login-failed":       ErrLoginFailed,
+	"page-exception":     ErrPageException,
+}
+
+// the devtools errors seen when a single page app re-renders the node an
+// action was working on
+var nodeDetachedMessages = []string{
+	"could not find node with given id",
+	"node is detached from document",
+	"no node with given id found",
+	"cannot find context with specified id",
+}
+
+// marks errors caused by the page replacing a node mid action
+func classifyBrowserError(err error) error {
+	if err == nil || errors.Is(err, ErrNodeDetached) {
+		return err
+	}
+	message := strings.ToLower(err.Error())
+	for _, detached := range nodeDetachedMessages {
+		if strings.Contains(message, detached) {
+			return fmt.Errorf("%w: %w", ErrNodeDetached, err)
+		}
+	}
+	return err
+}
+
+// RetryPolicy says how often, and after how long, a failing step is tried
+// again.
+type RetryPolicy struct {
+	// how many times the step is tried in total, below 2 means no retries
+	Attempts int
+	// wait before the first retry
+	Backoff time.Duration
+	// growth of the wait after each retry, below 1 keeps it constant
+	Multiplier float64
+	// upper bound of the wait, zero means unbounded
+	MaxBackoff time.Duration
+	// kinds of error that are retried, every error when empty
+	RetryOn []error
+}
+
+// AutomationRetries are the policies applied to each browser action and
+// to each account download as a whole.
+type AutomationRetries struct {
+	Action  RetryPolicy
+	Account RetryPolicy
+}
+
+func DefaultAutomationRetries() AutomationRetries {
+	return AutomationRetries{
+		// nodes replaced by a re-render are found again straight away
+		Action: RetryPolicy{
+			Attempts:   3,
+			Backoff:    500 * time.Millisecond,
+			Multiplier: 2,
+			RetryOn:    []error{ErrNodeDetached},
+		},
+		Account: RetryPolicy{
+			Attempts: 1,
+		},
+	}
+}
+
+// ParseErrorClasses looks up each class name in ErrorClasses.
+func ParseErrorClasses(names []string) ([]error, error) {
+	output := []error{}
+	for _, name := range names {
+		class, ok := ErrorClasses[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown error class: %s, expected one of %s", name, strings.Join(SortedKeys(ErrorClasses), ", "))
+		}
+		output = append(output, class)
+	}
+	return output, nil
+}
+
+// Retries reports whether the policy retries err.
+func (p RetryPolicy) Retries(err error) bool {
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	for _, class := range p.RetryOn {
+		if errors.Is(err, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay is how long to wait after the given failed attempt, counting from 1.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && p.Multiplier > 1; i++ {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// RetryAttempt is one try of a step that failed.
+type RetryAttempt struct {
+	Number   int
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// RetryError is the failure of a step that was tried more than once,
+// carrying every attempt.
+type RetryError struct {
+	Step     string
+	Attempts []RetryAttempt
+}
+
+func (e *RetryError) Error() string {
+	attempts := []string{}
+	for _, attempt := range e.Attempts {
+		attempts = append(attempts, fmt.Sprintf(
+			"attempt %d after %s: %s",
+			attempt.Number, attempt.Duration.Round(time.Millisecond), attempt.Err,
+		))
+	}
+	return fmt.Sprintf("%s failed %d times: %s", e.Step, len(e.Attempts), strings.Join(attempts, "; "))
+}
+
+func (e *RetryError) Unwrap() []error {
+	errs := []error{}
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// Run calls fn until it succeeds or the policy gives up, waiting between
+// attempts. Nothing is retried once ctx is done.
+func (p RetryPolicy) Run(ctx context.Context, log *logrus.Entry, step string, fn func() error) error {
+	if log == nil {
+		log = logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	attempts := []RetryAttempt{}
+	for number := 1; ; number++ {
+		started := time.Now()
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		attempts = append(attempts, RetryAttempt{
+			Number:   number,
+			Started:  started,
+			Duration: time.Since(started),
+			Err:      err,
+		})
+
+		if number >= p.Attempts || !p.Retries(err) || ctx.Err() != nil {
+			break
+		}
+
+		delay := p.Delay(number)
+		log.Warnf("%s failed, retrying in %s (%d/%d): %s", step, delay, number+1, p.Attempts, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(attempts) == 1 {
+		return attempts[0].Err
+	}
+	return &RetryError{Step: step, Attempts: attempts}
+}
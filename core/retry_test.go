@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyRetriesListedClassesOnly(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, RetryOn: []error{ErrNodeDetached}}
+
+	calls := 0
+	err := policy.Run(context.Background(), nil, "click button", func() error {
+		calls++
+		return NewStepError("click", "button", ErrSelectorNotFound, errors.New("timeout"))
+	})
+	assert.Equal(t, 1, calls)
+	assert.ErrorIs(t, err, ErrSelectorNotFound)
+
+	calls = 0
+	err = policy.Run(context.Background(), nil, "click button", func() error {
+		calls++
+		if calls < 3 {
+			return classifyBrowserError(errors.New("Node is detached from document (-32000)"))
+		}
+		return nil
+	})
+	assert.Equal(t, 3, calls)
+	assert.NoError(t, err)
+}
+
+func TestRetryPolicyKeepsEveryAttempt(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3}
+
+	err := policy.Run(context.Background(), nil, "account savings", func() error {
+		return fmt.Errorf("%w: try again", ErrNavigationFailed)
+	})
+
+	var retryErr *RetryError
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Len(t, retryErr.Attempts, 3)
+	assert.Equal(t, 3, retryErr.Attempts[2].Number)
+	assert.ErrorIs(t, err, ErrNavigationFailed)
+	assert.Contains(t, err.Error(), "account savings failed 3 times")
+}
+
+func TestRetryPolicyStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{Attempts: 5, Backoff: time.Hour}
+
+	calls := 0
+	err := policy.Run(ctx, nil, "find header", func() error {
+		calls++
+		cancel()
+		return ErrSelectorNotFound
+	})
+
+	assert.Equal(t, 1, calls)
+	assert.ErrorIs(t, err, ErrSelectorNotFound)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, Multiplier: 2, MaxBackoff: 5 * time.Second}
+
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+	assert.Equal(t, 5*time.Second, policy.Delay(4))
+
+	_, err := ParseErrorClasses([]string{"node-detached", "spinning"})
+	assert.ErrorContains(t, err, "unknown error class: spinning")
+}
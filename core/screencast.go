@@ -0,0 +1,312 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// ScreencastFormat is how a recorded screencast is saved.
+type ScreencastFormat string
+
+const (
+	// numbered png frames with an index of when each was shown
+	ScreencastFormatPNG ScreencastFormat = "png"
+	// a single animated gif, played back at the recorded pace
+	ScreencastFormatGIF ScreencastFormat = "gif"
+)
+
+func ParseScreencastFormat(format string) (ScreencastFormat, error) {
+	switch ScreencastFormat(format) {
+	case ScreencastFormatPNG, ScreencastFormatGIF:
+		return ScreencastFormat(format), nil
+	}
+	return "", fmt.Errorf("unknown screencast format: %s, expected %s or %s", format, ScreencastFormatPNG, ScreencastFormatGIF)
+}
+
+// the file listing the frames of a screencast
+const screencastIndexFile = "index.json"
+
+// what the browser is asked to send, a long run at full size and every
+// frame painted runs into gigabytes
+const (
+	screencastEveryNthFrame = 2
+	screencastMaxWidth      = 1024
+	screencastMaxHeight     = 768
+)
+
+// frames beyond this are merged into their neighbours in a gif, which has
+// to be held in memory whole while it is encoded
+const screencastMaxGIFFrames = 150
+
+// ScreencastFrame is one frame the browser painted.
+type ScreencastFrame struct {
+	File      string    `json:"file"`
+	Timestamp time.Time `json:"timestamp"`
+	// milliseconds since the first frame
+	Offset int64 `json:"offsetMs"`
+	// milliseconds until the next frame replaced it
+	Duration int64 `json:"durationMs"`
+}
+
+// ScreencastIndex lists the frames of a screencast in the order shown.
+type ScreencastIndex struct {
+	Frames []ScreencastFrame `json:"frames"`
+}
+
+// ScreencastRecorder writes the frames the browser sends while screencasting
+// into a directory, as numbered png files.
+type ScreencastRecorder struct {
+	Dir string
+	// tells the browser a frame arrived, it sends no more until then
+	ack func(sessionID int64) error
+
+	mu     sync.Mutex
+	frames []ScreencastFrame
+	// of the last frame kept, to skip frames that repeat it
+	lastSum uint64
+	errs    []error
+	stopped bool
+	writes  sync.WaitGroup
+	// stops the browser sending frames
+	stop   func() error
+	cancel context.CancelFunc
+}
+
+func NewScreencastRecorder(dir string, ack func(sessionID int64) error) *ScreencastRecorder {
+	return &ScreencastRecorder{
+		Dir:    dir,
+		ack:    ack,
+		frames: []ScreencastFrame{},
+	}
+}
+
+// RecordScreencast starts recording what the browser tab shows into dir,
+// until the returned recorder is stopped.
+func (a *Automation) RecordScreencast(dir string) (*ScreencastRecorder, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("could not record screencast: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(a.Context)
+	recorder := NewScreencastRecorder(dir, func(sessionID int64) error {
+		return chromedp.Run(ctx, page.ScreencastFrameAck(sessionID))
+	})
+	recorder.cancel = cancel
+	recorder.stop = func() error {
+		// the run context may be over by the time the recording stops
+		ctx, cancel := context.WithTimeout(a.browserContext, 15*time.Second)
+		defer cancel()
+		return chromedp.Run(ctx, page.StopScreencast())
+	}
+	chromedp.ListenTarget(ctx, recorder.HandleEvent)
+
+	a.Log.Debugf("Recording screencast into %s", dir)
+	err := chromedp.Run(a.Context,
+		page.StartScreencast().
+			WithFormat(page.ScreencastFormatPng).
+			WithEveryNthFrame(screencastEveryNthFrame).
+			WithMaxWidth(screencastMaxWidth).
+			WithMaxHeight(screencastMaxHeight),
+	)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("could not record screencast: %w", err)
+	}
+	return recorder, nil
+}
+
+// HandleEvent saves screencast frames, give it to chromedp.ListenTarget
+func (r *ScreencastRecorder) HandleEvent(v interface{}) {
+	ev, ok := v.(*page.EventScreencastFrame)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	// a page that isn't changing repaints the same frame, which stays on
+	// screen until the next different one instead
+	hash := fnv.New64a()
+	hash.Write([]byte(ev.Data))
+	sum := hash.Sum64()
+	if len(r.frames) > 0 && sum == r.lastSum {
+		r.writes.Add(1)
+		go func(sessionID int64) {
+			defer r.writes.Done()
+			r.acknowledge(sessionID, "a repeated frame", nil)
+		}(ev.SessionID)
+		return
+	}
+	r.lastSum = sum
+
+	timestamp := time.Now()
+	if ev.Metadata != nil && ev.Metadata.Timestamp != nil {
+		timestamp = ev.Metadata.Timestamp.Time()
+	}
+	frame := ScreencastFrame{
+		File:      fmt.Sprintf("frame-%05d.png", len(r.frames)+1),
+		Timestamp: timestamp,
+	}
+	r.frames = append(r.frames, frame)
+
+	// listeners must not block, so the frame is written elsewhere
+	r.writes.Add(1)
+	go func(ev *page.EventScreencastFrame, frame ScreencastFrame) {
+		defer r.writes.Done()
+		r.acknowledge(ev.SessionID, frame.File, r.writeFrame(ev.Data, frame.File))
+	}(ev, frame)
+}
+
+// tells the browser the frame arrived, recording err of handling it
+func (r *ScreencastRecorder) acknowledge(sessionID int64, what string, err error) {
+	if r.ack != nil {
+		err = errors.Join(err, r.ack(sessionID))
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.errs = append(r.errs, fmt.Errorf("could not record %s: %w", what, err))
+		r.mu.Unlock()
+	}
+}
+
+func (r *ScreencastRecorder) writeFrame(data string, file string) error {
+	content, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.Dir, file), content, 0640)
+}
+
+// Stop stops recording, waiting for frames still being written, and
+// writes the index of the frames next to them.
+func (r *ScreencastRecorder) Stop() (ScreencastIndex, error) {
+	r.mu.Lock()
+	r.stopped = true
+	stoppedAt := time.Now()
+	r.mu.Unlock()
+
+	errs := []error{}
+	if r.stop != nil {
+		errs = append(errs, r.stop())
+	}
+	r.writes.Wait()
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index := ScreencastIndex{Frames: append([]ScreencastFrame{}, r.frames...)}
+	for i := range index.Frames {
+		frame := &index.Frames[i]
+		frame.Offset = frame.Timestamp.Sub(index.Frames[0].Timestamp).Milliseconds()
+		// the last frame stays on screen until the recording stops
+		until := stoppedAt
+		if i+1 < len(index.Frames) {
+			until = index.Frames[i+1].Timestamp
+		}
+		frame.Duration = until.Sub(frame.Timestamp).Milliseconds()
+	}
+
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(r.Dir, screencastIndexFile), content, 0640)
+	}
+	errs = append(errs, err)
+	errs = append(errs, r.errs...)
+
+	return index, errors.Join(errs...)
+}
+
+// merges consecutive frames so there are at most max of them, each shown
+// for as long as those merged into it were
+func sampleScreencastFrames(frames []ScreencastFrame, max int) []ScreencastFrame {
+	if len(frames) <= max {
+		return frames
+	}
+	group := (len(frames) + max - 1) / max
+	output := []ScreencastFrame{}
+	for start := 0; start < len(frames); start += group {
+		frame := frames[start]
+		for _, merged := range frames[start+1 : min(start+group, len(frames))] {
+			frame.Duration += merged.Duration
+		}
+		output = append(output, frame)
+	}
+	return output
+}
+
+// SaveScreencastGIF turns the frames of a stopped recording into an
+// animated gif at path. Long recordings are played back with fewer frames,
+// the png frames keep every one.
+func SaveScreencastGIF(dir string, index ScreencastIndex, path string) error {
+	if len(index.Frames) == 0 {
+		return fmt.Errorf("no screencast frames were recorded in %s", dir)
+	}
+
+	animation := &gif.GIF{}
+	for _, frame := range sampleScreencastFrames(index.Frames, screencastMaxGIFFrames) {
+		file, err := os.Open(filepath.Join(dir, frame.File))
+		if err != nil {
+			return err
+		}
+		img, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", frame.File, err)
+		}
+
+		paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+		animation.Image = append(animation.Image, paletted)
+
+		// gif delays are in hundredths of a second, and viewers play
+		// anything shorter than 2 as slowly as 10
+		delay := int(frame.Duration / 10)
+		if delay < 2 {
+			delay = 2
+		}
+		animation.Delay = append(animation.Delay, delay)
+
+		// the viewport can change size during a run
+		bounds := img.Bounds()
+		if bounds.Max.X > animation.Config.Width {
+			animation.Config.Width = bounds.Max.X
+		}
+		if bounds.Max.Y > animation.Config.Height {
+			animation.Config.Height = bounds.Max.Y
+		}
+	}
+	animation.Config.ColorModel = animation.Image[0].ColorModel()
+
+	output, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(output, animation); err != nil {
+		output.Close()
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return output.Close()
+}
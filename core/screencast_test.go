@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func screencastFrame(t *testing.T, sessionID int64, at time.Time, fill color.Color) *page.EventScreencastFrame {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 6; y++ {
+			img.Set(x, y, fill)
+		}
+	}
+	var content bytes.Buffer
+	assert.NoError(t, png.Encode(&content, img))
+
+	timestamp := cdp.TimeSinceEpoch(at)
+	return &page.EventScreencastFrame{
+		Data:      base64.StdEncoding.EncodeToString(content.Bytes()),
+		Metadata:  &page.ScreencastFrameMetadata{Timestamp: &timestamp},
+		SessionID: sessionID,
+	}
+}
+
+func TestScreencastRecorderWritesFramesAndIndex(t *testing.T) {
+	dir := t.TempDir()
+	acked := make(chan int64, 3)
+	recorder := NewScreencastRecorder(dir, func(sessionID int64) error {
+		acked <- sessionID
+		return nil
+	})
+
+	started := time.Now().Add(-time.Second)
+	recorder.HandleEvent(screencastFrame(t, 1, started, color.White))
+	recorder.HandleEvent(screencastFrame(t, 2, started.Add(250*time.Millisecond), color.Black))
+	recorder.HandleEvent(&page.EventScreencastVisibilityChanged{Visible: true})
+
+	index, err := recorder.Stop()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{1, 2}, []int64{<-acked, <-acked})
+
+	// frames arriving after stopping are ignored
+	recorder.HandleEvent(screencastFrame(t, 3, time.Now(), color.White))
+
+	assert.Len(t, index.Frames, 2)
+	assert.Equal(t, "frame-00001.png", index.Frames[0].File)
+	assert.Equal(t, int64(0), index.Frames[0].Offset)
+	assert.Equal(t, int64(250), index.Frames[0].Duration)
+	assert.Equal(t, "frame-00002.png", index.Frames[1].File)
+	assert.Equal(t, int64(250), index.Frames[1].Offset)
+	assert.GreaterOrEqual(t, index.Frames[1].Duration, int64(750))
+
+	content, err := os.ReadFile(filepath.Join(dir, screencastIndexFile))
+	assert.NoError(t, err)
+	var saved ScreencastIndex
+	assert.NoError(t, json.Unmarshal(content, &saved))
+	assert.Equal(t, len(index.Frames), len(saved.Frames))
+
+	for _, frame := range index.Frames {
+		_, err := os.Stat(filepath.Join(dir, frame.File))
+		assert.NoError(t, err)
+	}
+
+	output := filepath.Join(t.TempDir(), "run.gif")
+	assert.NoError(t, SaveScreencastGIF(dir, index, output))
+
+	file, err := os.Open(output)
+	assert.NoError(t, err)
+	defer file.Close()
+	animation, err := gif.DecodeAll(file)
+	assert.NoError(t, err)
+	assert.Len(t, animation.Image, 2)
+	assert.Equal(t, 25, animation.Delay[0])
+}
+
+func TestScreencastRecorderSkipsRepeatedFrames(t *testing.T) {
+	acked := make(chan int64, 3)
+	recorder := NewScreencastRecorder(t.TempDir(), func(sessionID int64) error {
+		acked <- sessionID
+		return nil
+	})
+
+	started := time.Now().Add(-time.Second)
+	recorder.HandleEvent(screencastFrame(t, 1, started, color.White))
+	recorder.HandleEvent(screencastFrame(t, 2, started.Add(100*time.Millisecond), color.White))
+	recorder.HandleEvent(screencastFrame(t, 3, started.Add(200*time.Millisecond), color.Black))
+
+	index, err := recorder.Stop()
+	assert.NoError(t, err)
+	// repeats are still acknowledged, or the browser sends no more
+	assert.ElementsMatch(t, []int64{1, 2, 3}, []int64{<-acked, <-acked, <-acked})
+	assert.Len(t, index.Frames, 2)
+	assert.Equal(t, int64(200), index.Frames[0].Duration)
+}
+
+func TestSampleScreencastFrames(t *testing.T) {
+	frames := []ScreencastFrame{}
+	for i := 0; i < 5; i++ {
+		frames = append(frames, ScreencastFrame{File: fmt.Sprintf("frame-%d.png", i), Duration: 100})
+	}
+
+	assert.Equal(t, frames, sampleScreencastFrames(frames, 5))
+
+	sampled := sampleScreencastFrames(frames, 2)
+	assert.Len(t, sampled, 2)
+	assert.Equal(t, "frame-0.png", sampled[0].File)
+	assert.Equal(t, int64(300), sampled[0].Duration)
+	assert.Equal(t, "frame-3.png", sampled[1].File)
+	assert.Equal(t, int64(200), sampled[1].Duration)
+}
+
+func TestSaveScreencastGIFNeedsFrames(t *testing.T) {
+	err := SaveScreencastGIF(t.TempDir(), ScreencastIndex{}, filepath.Join(t.TempDir(), "run.gif"))
+	assert.Error(t, err)
+}
+
+func TestParseScreencastFormat(t *testing.T) {
+	format, err := ParseScreencastFormat("gif")
+	assert.NoError(t, err)
+	assert.Equal(t, ScreencastFormatGIF, format)
+
+	_, err = ParseScreencastFormat("webm")
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/cdproto"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Selectors can reach into frames and shadow roots by chaining steps:
+//
+//	frame=<name, id or url> >> selector   searches inside a frame
+//	selector >> selector                  searches inside an element
+//	selector >>> selector                 searches inside an element's shadow root
+//
+// Each selector is css, or xpath when it starts with / or ( or ./
+// eg: frame=*login.bank.example* >> login-form >>> input[name='password']
+var selectorChain = regexp.MustCompile(`\s*(>>>|>>)\s*`)
+
+const framePrefix = "frame="
+
+// SelectorStep is one link of a chained selector.
+type SelectorStep struct {
+	// a css or xpath selector, empty for frame steps
+	Selector string `json:"selector,omitempty"`
+	// name or id of a frame
+	Frame string `json:"frame,omitempty"`
+	// pattern matching the url of a frame
+	FrameURL string `json:"frameUrl,omitempty"`
+	// the next step searches the shadow root of what this one found
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// IsChainedSelector reports whether selector needs resolving step by step.
+func IsChainedSelector(selector string) bool {
+	return selectorChain.MatchString(selector) || strings.HasPrefix(selector, framePrefix)
+}
+
+// ParseSelector splits a chained selector into its steps.
+func ParseSelector(selector string) ([]SelectorStep, error) {
+	parts := selectorChain.Split(selector, -1)
+	separators := selectorChain.FindAllStringSubmatch(selector, -1)
+
+	steps := []SelectorStep{}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("%w: empty step in %s", ErrSelectorNotFound, selector)
+		}
+
+		step := SelectorStep{Selector: part}
+		if frame, ok := strings.CutPrefix(part, framePrefix); ok {
+			pattern, err := WildcardPattern(UnQuote(frame))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrSelectorNotFound, err)
+			}
+			step = SelectorStep{Frame: UnQuote(frame), FrameURL: pattern.String()}
+			if i == len(parts)-1 {
+				return nil, fmt.Errorf("%w: nothing to find inside frame %s", ErrSelectorNotFound, frame)
+			}
+		}
+		if i < len(separators) && separators[i][1] == ">>>" {
+			if step.Frame != "" {
+				return nil, fmt.Errorf("%w: frames have no shadow root: %s", ErrSelectorNotFound, part)
+			}
+			step.Shadow = true
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// walks the steps in the page, returning the element found or null
+const resolveSelectorScript = `((steps) => {
+	const find = (scope, selector) => {
+		if (/^(\/|\(|\.\/)/.test(selector)) {
+			const path = selector.startsWith("/") ? "." + selector : selector;
+			const doc = scope.ownerDocument || scope;
+			return doc.evaluate(path, scope, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null).singleNodeValue;
+		}
+		return scope.querySelector(selector);
+	};
+	let scope = document;
+	for (const step of steps) {
+		if (!scope) {
+			return null;
+		}
+		if (step.frame) {
+			const url = new RegExp(step.frameUrl);
+			const frame = Array.from(scope.querySelectorAll("iframe, frame")).find(
+				(frame) => frame.name === step.frame || frame.id === step.frame || url.test(frame.src)
+			);
+			// cross origin frames have no document we can reach, waiting
+			// won't change that
+			if (frame && !frame.contentDocument) {
+				throw new Error("` + crossOriginFrameMessage + `: " + step.frame);
+			}
+			scope = frame && frame.contentDocument;
+			continue;
+		}
+		const node = find(scope, step.selector);
+		scope = step.shadow ? node && node.shadowRoot : node;
+	}
+	return scope;
+})(%s)`
+
+// thrown by resolveSelectorScript for frames of another origin
+const crossOriginFrameMessage = "cross-origin frame not reachable"
+
+// a selector that reaches into a cross-origin frame, which is never found
+// however long it is waited for
+type crossOriginFrameError struct {
+	message string
+}
+
+func (e *crossOriginFrameError) Error() string {
+	return e.message
+}
+
+// chromedp stops waiting for errors of this message, since it takes them
+// for invalid selectors
+func (e *crossOriginFrameError) Unwrap() []error {
+	return []error{ErrFrameUnreachable, &cdproto.Error{Message: "DOM Error while querying"}}
+}
+
+// like chromedp.ByJSPath, except that a selector reaching into a
+// cross-origin frame fails at once instead of waiting out its timeout
+func byResolveScript(expression string) chromedp.QueryOption {
+	return chromedp.ByFunc(func(ctx context.Context, node *cdp.Node) ([]cdp.NodeID, error) {
+		value, exception, err := runtime.Evaluate(expression).
+			WithAwaitPromise(true).
+			WithObjectGroup("console").
+			Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if exception != nil {
+			if exception.Exception != nil && strings.Contains(exception.Exception.Description, crossOriginFrameMessage) {
+				message, _, _ := strings.Cut(exception.Exception.Description, "\n")
+				return nil, &crossOriginFrameError{message: strings.TrimPrefix(message, "Error: ")}
+			}
+			return nil, exception
+		}
+
+		nodeID, err := dom.RequestNode(value.ObjectID).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if nodeID == cdp.EmptyNodeID {
+			return []cdp.NodeID{}, nil
+		}
+		return []cdp.NodeID{nodeID}, nil
+	})
+}
+
+// the selector and query options chromedp needs to find selector
+func querySelector(selector string) (interface{}, []chromedp.QueryOption, error) {
+	if !IsChainedSelector(selector) {
+		return selector, nil, nil
+	}
+
+	steps, err := ParseSelector(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	encoded, err := json.Marshal(steps)
+	if err != nil {
+		return nil, nil, err
+	}
+	expression := fmt.Sprintf(resolveSelectorScript, encoded)
+	return expression, []chromedp.QueryOption{byResolveScript(expression)}, nil
+}
+
+// reads fields, found relative to each element the last step matches, of
+// every such element. Fields that aren't found are empty
+const readAllScript = `((scope, selector, fields) => {
+	const isXPath = (selector) => /^(\/|\(|\.\/)/.test(selector);
+	const findAll = (scope, selector) => {
+		if (isXPath(selector)) {
+			const path = selector.startsWith("/") ? "." + selector : selector;
+			const doc = scope.ownerDocument || scope;
+			const found = doc.evaluate(path, scope, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+			return Array.from({ length: found.snapshotLength }, (_, i) => found.snapshotItem(i));
+		}
+		return Array.from(scope.querySelectorAll(selector));
+	};
+	const text = (node) => (node ? node.innerText || node.textContent || "" : "").trim();
+	if (!scope) {
+		return [];
+	}
+	return findAll(scope, selector).map((node) => {
+		const entry = {};
+		for (const [name, field] of Object.entries(fields)) {
+			entry[name] = field === "" ? text(node) : text(findAll(node, field)[0]);
+		}
+		return entry;
+	});
+})(%s, %s, %s)`
+
+// the script ReadAll evaluates to read fields of every element selector
+// matches
+func readAllExpression(selector string, fields map[string]string) (string, error) {
+	steps, err := ParseSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	last := steps[len(steps)-1]
+	scope := "document"
+	if len(steps) > 1 {
+		encoded, err := json.Marshal(steps[:len(steps)-1])
+		if err != nil {
+			return "", err
+		}
+		scope = fmt.Sprintf(resolveSelectorScript, encoded)
+	}
+	encodedSelector, err := json.Marshal(last.Selector)
+	if err != nil {
+		return "", err
+	}
+	encodedFields, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(readAllScript, scope, encodedSelector, encodedFields), nil
+}
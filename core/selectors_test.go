@@ -0,0 +1,83 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelectorSteps(t *testing.T) {
+	steps, err := ParseSelector("frame=*login.bank.example* >> login-form >>> input[name='password']")
+	assert.NoError(t, err)
+	assert.Equal(t, []SelectorStep{
+		{Frame: "*login.bank.example*", FrameURL: `^.*login\.bank\.example.*$`},
+		{Selector: "login-form", Shadow: true},
+		{Selector: "input[name='password']"},
+	}, steps)
+
+	steps, err = ParseSelector("#statements>>//a[text()='Export']")
+	assert.NoError(t, err)
+	assert.Equal(t, []SelectorStep{
+		{Selector: "#statements"},
+		{Selector: "//a[text()='Export']"},
+	}, steps)
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, selector := range []string{
+		"#form >> ",
+		"frame=login",
+		"frame=login >>> input",
+		">> input",
+	} {
+		_, err := ParseSelector(selector)
+		assert.True(t, errors.Is(err, ErrSelectorNotFound), selector)
+	}
+}
+
+func TestQuerySelectorLeavesPlainSelectorsAlone(t *testing.T) {
+	for _, selector := range []string{
+		"#login > input",
+		"//button[contains(text(), 'Log in')]",
+		"div[data-test-id='frame=x']",
+	} {
+		query, options, err := querySelector(selector)
+		assert.NoError(t, err)
+		assert.Equal(t, selector, query)
+		assert.Empty(t, options)
+	}
+}
+
+func TestQuerySelectorResolvesChainsWithScript(t *testing.T) {
+	query, options, err := querySelector("frame=login >> #password")
+	assert.NoError(t, err)
+	assert.Len(t, options, 1)
+	assert.True(t, strings.Contains(query.(string), `[{"frame":"login","frameUrl":"^login$"},{"selector":"#password"}]`))
+}
+
+func TestReadAllExpressionScopesChainsToTheLastStep(t *testing.T) {
+	expression, err := readAllExpression("#main-div", map[string]string{"name": ".name"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(expression, `(document, "#main-div", {"name":".name"})`))
+
+	expression, err = readAllExpression("frame=accounts >> li", map[string]string{})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(expression, `[{"frame":"accounts","frameUrl":"^accounts$"}]`))
+	assert.True(t, strings.HasSuffix(expression, `, "li", {})`))
+}
+
+func TestCrossOriginFramesFailAtOnce(t *testing.T) {
+	query, _, err := querySelector("frame=login >> #password")
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(query.(string), `throw new Error("cross-origin frame not reachable: " + step.frame)`))
+
+	err = &crossOriginFrameError{message: "cross-origin frame not reachable: login"}
+	assert.ErrorIs(t, err, ErrFrameUnreachable)
+	// what makes chromedp stop waiting for the selector
+	var domErr *cdproto.Error
+	assert.True(t, errors.As(err, &domErr))
+	assert.Equal(t, "DOM Error while querying", domErr.Message)
+}
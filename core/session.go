@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// SessionState is everything the browser needs to stay logged into a
+// source: its cookies plus the storage of the page it was captured on.
+type SessionState struct {
+	// the page the session was captured on
+	URL string `json:"url"`
+	// the origin owning the storage below
+	Origin         string            `json:"origin"`
+	Cookies        []SessionCookie   `json:"cookies"`
+	LocalStorage   map[string]string `json:"localStorage"`
+	SessionStorage map[string]string `json:"sessionStorage"`
+	SavedAt        time.Time         `json:"savedAt"`
+}
+
+// SessionCookie is a saved browser cookie, kept apart from the devtools
+// types so that saved sessions survive upgrades of them.
+type SessionCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+	// seconds since the unix epoch, ignored for session cookies
+	Expires  float64 `json:"expires"`
+	Session  bool    `json:"session"`
+	Secure   bool    `json:"secure"`
+	HTTPOnly bool    `json:"httpOnly"`
+	SameSite string  `json:"sameSite,omitempty"`
+}
+
+func NewSessionCookie(cookie *network.Cookie) SessionCookie {
+	return SessionCookie{
+		Name:     cookie.Name,
+		Value:    cookie.Value,
+		Domain:   cookie.Domain,
+		Path:     cookie.Path,
+		Expires:  cookie.Expires,
+		Session:  cookie.Session,
+		Secure:   cookie.Secure,
+		HTTPOnly: cookie.HTTPOnly,
+		SameSite: cookie.SameSite.String(),
+	}
+}
+
+// the storage of the current page
+type pageStorage struct {
+	Origin         string            `json:"origin"`
+	LocalStorage   map[string]string `json:"localStorage"`
+	SessionStorage map[string]string `json:"sessionStorage"`
+}
+
+const captureStorageScript = `(() => {
+	const dump = (store) => Object.fromEntries(
+		Object.keys(store).map((key) => [key, store.getItem(key)])
+	);
+	return {
+		origin: location.origin,
+		localStorage: dump(localStorage),
+		sessionStorage: dump(sessionStorage),
+	};
+})()`
+
+// only writes the storage back when the page is still on the same origin
+const restoreStorageScript = `((origin, local, session) => {
+	if (location.origin !== origin) {
+		return false;
+	}
+	Object.entries(local || {}).forEach(([key, value]) => localStorage.setItem(key, value));
+	Object.entries(session || {}).forEach(([key, value]) => sessionStorage.setItem(key, value));
+	return true;
+})(%s, %s, %s)`
+
+// CookieParams turns the saved cookies back into ones the browser accepts,
+// dropping any that expired since they were saved.
+func (s *SessionState) CookieParams(now time.Time) []*network.CookieParam {
+	params := []*network.CookieParam{}
+	for _, cookie := range s.Cookies {
+		param := &network.CookieParam{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HTTPOnly: cookie.HTTPOnly,
+			SameSite: network.CookieSameSite(cookie.SameSite),
+		}
+		if !cookie.Session && cookie.Expires > 0 {
+			expires := time.Unix(0, int64(cookie.Expires*float64(time.Second)))
+			if !expires.After(now) {
+				continue
+			}
+			since := cdp.TimeSinceEpoch(expires)
+			param.Expires = &since
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// CaptureSession reads the cookies of the browser and the storage of the
+// current page.
+func (a *Automation) CaptureSession() (*SessionState, error) {
+	a.Log.Debug("Capturing session")
+
+	state := &SessionState{SavedAt: time.Now()}
+	var page pageStorage
+	err := chromedp.Run(a.Context,
+		chromedp.Location(&state.URL),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			cookies, err := storage.GetCookies().WithBrowserContextID(a.BrowserContextID()).Do(ctx)
+			for _, cookie := range cookies {
+				state.Cookies = append(state.Cookies, NewSessionCookie(cookie))
+			}
+			return err
+		}),
+		chromedp.Evaluate(captureStorageScript, &page),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not capture session: %w", err)
+	}
+
+	state.Origin = page.Origin
+	state.LocalStorage = page.LocalStorage
+	state.SessionStorage = page.SessionStorage
+
+	return state, nil
+}
+
+// RestoreSession puts a captured session back into the browser, leaving
+// it on the page the session was captured on.
+func (a *Automation) RestoreSession(state *SessionState) error {
+	a.Log.Debugf("Restoring session saved at %s", state.SavedAt.Format(time.RFC3339))
+
+	args := []string{}
+	for _, value := range []interface{}{state.Origin, state.LocalStorage, state.SessionStorage} {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("could not restore session: %w", err)
+		}
+		args = append(args, string(encoded))
+	}
+
+	var restored bool
+	err := a.run("restore session", a.Options.Timeouts.Navigate,
+		storage.SetCookies(state.CookieParams(time.Now())).WithBrowserContextID(a.BrowserContextID()),
+		chromedp.Navigate(state.URL),
+		chromedp.WaitVisible("body"),
+		chromedp.Evaluate(fmt.Sprintf(restoreStorageScript, args[0], args[1], args[2]), &restored),
+	)
+	if err != nil {
+		return NewStepError("restore session", state.URL, ErrSessionExpired, err)
+	}
+	if !restored {
+		a.Log.Debugf("Left the storage of %s alone, the page redirected elsewhere", state.Origin)
+	}
+
+	return nil
+}
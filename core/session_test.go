@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStateDropsExpiredCookies(t *testing.T) {
+	now := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	state := &SessionState{
+		Cookies: []SessionCookie{
+			{Name: "session", Value: "a", Domain: "bank.example", Session: true, Expires: -1},
+			{Name: "remember", Value: "b", Domain: "bank.example", Expires: float64(now.Add(time.Hour).Unix())},
+			{Name: "stale", Value: "c", Domain: "bank.example", Expires: float64(now.Add(-time.Hour).Unix())},
+		},
+	}
+
+	params := state.CookieParams(now)
+
+	assert.Len(t, params, 2)
+	assert.Equal(t, "session", params[0].Name)
+	assert.Nil(t, params[0].Expires)
+	assert.Equal(t, "remember", params[1].Name)
+	assert.Equal(t, now.Add(time.Hour), params[1].Expires.Time().UTC())
+}
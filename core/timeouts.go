@@ -0,0 +1,187 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// AutomationTimeouts bounds how long each part of a run may take.
+// A zero duration means no limit.
+type AutomationTimeouts struct {
+	// waiting for a selector to become visible
+	Find time.Duration
+	// clicking, focusing or filling a selector
+	Click time.Duration
+	// loading a url
+	Navigate time.Duration
+	// waiting for a download to complete
+	Download time.Duration
+	// logging into a source
+	Login time.Duration
+	// everything done for a single account
+	Account time.Duration
+	// the whole run
+	Run time.Duration
+}
+
+func DefaultAutomationTimeouts() AutomationTimeouts {
+	return AutomationTimeouts{
+		Find:     30 * time.Second,
+		Click:    30 * time.Second,
+		Navigate: 60 * time.Second,
+		Download: 2 * time.Minute,
+		Login:    5 * time.Minute,
+		Account:  5 * time.Minute,
+		Run:      30 * time.Minute,
+	}
+}
+
+// a deadline placed on part of a run, scopes nest inside each other
+type deadlineScope struct {
+	step    string
+	timeout time.Duration
+	ctx     context.Context
+	parent  *deadlineScope
+}
+
+// finds the outermost scope whose deadline has passed
+func (s *deadlineScope) expired() *deadlineScope {
+	var found *deadlineScope
+	for scope := s; scope != nil; scope = scope.parent {
+		if errors.Is(scope.ctx.Err(), context.DeadlineExceeded) {
+			found = scope
+		}
+	}
+	return found
+}
+
+// names the scope that ran out of time, if that is why err happened
+func (s *deadlineScope) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	// already named by an inner step
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return err
+	}
+	expired := s.expired()
+	if expired == nil {
+		return err
+	}
+	return &TimeoutError{
+		Step:    expired.step,
+		Timeout: expired.timeout,
+		Err:     err,
+	}
+}
+
+func withOptionalTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// NewRunContext bounds a whole run by timeout, every automation given it
+// with WithRunContext shares the one deadline.
+func NewRunContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return withOptionalTimeout(context.Background(), timeout)
+}
+
+// a child of the browser context parent that ends along with run, which
+// can't be the parent since chromedp keeps its state in the context
+func withRunContext(parent context.Context, run context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := withRunDeadline(parent, run)
+	// the deadline is reported by ctx itself, anything else ends it early
+	stop := context.AfterFunc(run, func() {
+		if !errors.Is(run.Err(), context.DeadlineExceeded) {
+			cancel()
+		}
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// a child of parent bounded by the deadline of run, when it has one
+func withRunDeadline(parent context.Context, run context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := run.Deadline(); ok {
+		return context.WithDeadline(parent, deadline)
+	}
+	return context.WithCancel(parent)
+}
+
+// starts a scope nested inside the current one
+func (a *Automation) pushScope(step string, timeout time.Duration) (*deadlineScope, context.CancelFunc) {
+	ctx, cancel := withOptionalTimeout(a.Context, timeout)
+	scope := &deadlineScope{
+		step:    step,
+		timeout: timeout,
+		ctx:     ctx,
+		parent:  a.scope,
+	}
+	return scope, cancel
+}
+
+// Scope runs fn with every automation action bounded by timeout, errors
+// caused by running out of time name the step.
+func (a *Automation) Scope(step string, timeout time.Duration, fn func() error) error {
+	scope, cancel := a.pushScope(step, timeout)
+	defer cancel()
+
+	parentScope, parentContext := a.scope, a.Context
+	a.scope, a.Context = scope, scope.ctx
+	defer func() {
+		a.scope, a.Context = parentScope, parentContext
+	}()
+
+	return scope.wrap(fn())
+}
+
+// runs the actions once, bounded by timeout
+func (a *Automation) attempt(step string, timeout time.Duration, actions ...chromedp.Action) error {
+	scope, cancel := a.pushScope(step, timeout)
+	defer cancel()
+
+	a.attempts++
+	err := classifyBrowserError(chromedp.Run(scope.ctx, actions...))
+	if err == nil && a.exceptions != nil {
+		// the page threw while the step ran, or since the last one
+		err = a.exceptions.TakeFailure()
+	}
+	return scope.wrap(err)
+}
+
+// runs the actions for a single step, bounded by timeout and retried as
+// the action retry policy allows
+func (a *Automation) run(step string, timeout time.Duration, actions ...chromedp.Action) error {
+	return a.retryAction(step, func() error {
+		return a.attempt(step, timeout, actions...)
+	})
+}
+
+// like run, but failures are StepErrors of the given kind so that retry
+// policies can tell them apart
+func (a *Automation) runStep(action string, target string, kind error, timeout time.Duration, actions ...chromedp.Action) error {
+	step := action + " " + target
+	return a.retryAction(step, func() error {
+		if err := a.attempt(step, timeout, actions...); err != nil {
+			return NewStepError(action, target, kind, err)
+		}
+		return nil
+	})
+}
+
+// captures a failure bundle only once every attempt failed
+func (a *Automation) retryAction(step string, fn func() error) error {
+	err := a.Options.Retries.Action.Run(a.Context, a.Log, step, fn)
+	if err != nil {
+		a.captureFailure(step, err)
+	}
+	return err
+}
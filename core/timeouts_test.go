@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newScopedAutomation(timeout time.Duration) (*Automation, context.CancelFunc) {
+	ctx, cancel := withOptionalTimeout(context.Background(), timeout)
+	return &Automation{
+		Context: ctx,
+		scope: &deadlineScope{
+			step:    "run",
+			timeout: timeout,
+			ctx:     ctx,
+		},
+	}, cancel
+}
+
+func TestScopeNamesTheStepThatRanOutOfTime(t *testing.T) {
+	automation, cancel := newScopedAutomation(time.Minute)
+	defer cancel()
+
+	err := automation.Scope("account savings", 10*time.Millisecond, func() error {
+		<-automation.Context.Done()
+		return automation.Context.Err()
+	})
+
+	var timeoutErr *TimeoutError
+	assert.ErrorIs(t, err, ErrDeadlineExceeded)
+	assert.True(t, errors.As(err, &timeoutErr))
+	assert.Equal(t, "account savings", timeoutErr.Step)
+	assert.Equal(t, 10*time.Millisecond, timeoutErr.Timeout)
+}
+
+func TestScopeNamesTheOutermostExpiredScope(t *testing.T) {
+	automation, cancel := newScopedAutomation(10 * time.Millisecond)
+	defer cancel()
+
+	err := automation.Scope("account savings", time.Minute, func() error {
+		<-automation.Context.Done()
+		return automation.Context.Err()
+	})
+
+	var timeoutErr *TimeoutError
+	assert.True(t, errors.As(err, &timeoutErr))
+	assert.Equal(t, "run", timeoutErr.Step)
+}
+
+func TestScopeRestoresContext(t *testing.T) {
+	automation, cancel := newScopedAutomation(time.Minute)
+	defer cancel()
+	parent := automation.Context
+
+	err := automation.Scope("login", time.Minute, func() error {
+		assert.NotEqual(t, parent, automation.Context)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, parent, automation.Context)
+}
+
+func TestRunContextIsSharedByLaterAutomations(t *testing.T) {
+	run, cancelRun := NewRunContext(50 * time.Millisecond)
+	defer cancelRun()
+
+	first, cancelFirst := withRunContext(context.Background(), run)
+	defer cancelFirst()
+	time.Sleep(30 * time.Millisecond)
+	// created later, it still ends when the run does
+	second, cancelSecond := withRunContext(context.Background(), run)
+	defer cancelSecond()
+
+	firstDeadline, _ := first.Deadline()
+	secondDeadline, _ := second.Deadline()
+	assert.Equal(t, firstDeadline, secondDeadline)
+
+	<-second.Done()
+	assert.ErrorIs(t, second.Err(), context.DeadlineExceeded)
+}
+
+func TestRunContextCancelsItsAutomations(t *testing.T) {
+	run, cancelRun := NewRunContext(0)
+	ctx, cancel := withRunContext(context.Background(), run)
+	defer cancel()
+
+	cancelRun()
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gookit/color"
+)
+
+// the file each run writes its steps to, inside the run artifacts
+const TraceFile = "trace.jsonl"
+
+const (
+	TraceOutcomeOK     = "ok"
+	TraceOutcomeFailed = "failed"
+)
+
+// TraceContext says what an automation is working on, so that steps of
+// sources running side by side can be told apart.
+type TraceContext struct {
+	// the source being downloaded, eg: 01-anz
+	Source string `json:"source,omitempty"`
+	// the processor driving the browser, eg: anz
+	Processor string `json:"processor,omitempty"`
+	// the account being downloaded, empty while logging in
+	Account string `json:"account,omitempty"`
+}
+
+// TraceStep is one automation action, as written to the trace.
+type TraceStep struct {
+	TraceContext
+	// the automation action, eg: click
+	Action string `json:"action"`
+	// the selector, url or file the action was given
+	Target   string    `json:"target,omitempty"`
+	Started  time.Time `json:"started"`
+	Duration int64     `json:"durationMs"`
+	Outcome  string    `json:"outcome"`
+	// how many times the browser was asked, more than one means it was retried
+	Attempts int `json:"attempts"`
+	// one of ErrorClasses, when the error is of a known kind
+	Class string `json:"class,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s TraceStep) Ended() time.Time {
+	return s.Started.Add(time.Duration(s.Duration) * time.Millisecond)
+}
+
+// StepTrace appends steps to a jsonl file, it is safe to share between
+// automations running side by side. Nothing is written until needed.
+type StepTrace struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewStepTrace(path string) *StepTrace {
+	return &StepTrace{Path: path}
+}
+
+// Record appends step to the trace.
+func (t *StepTrace) Record(step TraceStep) error {
+	line, err := json.Marshal(step)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		if err := os.MkdirAll(filepath.Dir(t.Path), 0750); err != nil {
+			return fmt.Errorf("could not open trace: %w", err)
+		}
+		t.file, err = os.OpenFile(t.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return fmt.Errorf("could not open trace: %w", err)
+		}
+	}
+	_, err = t.file.Write(append(line, '\n'))
+	return err
+}
+
+func (t *StepTrace) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// ReadTrace reads the steps of a trace file, in the order they were written.
+func ReadTrace(path string) ([]TraceStep, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	steps := []TraceStep{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var step TraceStep
+		if err := json.Unmarshal(scanner.Bytes(), &step); err != nil {
+			return nil, fmt.Errorf("could not read %s line %d: %w", path, line, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+// ErrorClass names the kind of err, as used by retry policies, or is empty
+// when it is none of ErrorClasses.
+func ErrorClass(err error) string {
+	for _, name := range SortedKeys(ErrorClasses) {
+		if errors.Is(err, ErrorClasses[name]) {
+			return name
+		}
+	}
+	return ""
+}
+
+// returns a function that records the step once given the error the
+// action finished with, use with a named error result:
+//
+//	defer a.traceStep("click", selector)(&err)
+func (a *Automation) traceStep(action string, target string) func(*error) {
+	started := time.Now()
+	attempts := a.attempts
+
+	return func(err *error) {
+		if a.Options.Trace == nil {
+			return
+		}
+		step := TraceStep{
+			TraceContext: a.TraceContext,
+			Action:       action,
+			Target:       target,
+			Started:      started,
+			Duration:     time.Since(started).Milliseconds(),
+			Outcome:      TraceOutcomeOK,
+			Attempts:     a.attempts - attempts,
+		}
+		if step.Attempts < 1 {
+			step.Attempts = 1
+		}
+		if *err != nil {
+			step.Outcome = TraceOutcomeFailed
+			step.Class = ErrorClass(*err)
+			step.Error = (*err).Error()
+		}
+		if recordErr := a.Options.Trace.Record(step); recordErr != nil {
+			a.Log.Warnf("could not trace %s %s: %s", action, target, recordErr)
+		}
+	}
+}
+
+// the width of the timeline bars drawn by RenderTrace
+const traceBarWidth = 30
+
+// RenderTrace draws the steps as a timeline, each step a bar placed where
+// it happened between the first step starting and the last one ending.
+func RenderTrace(w io.Writer, steps []TraceStep) {
+	if len(steps) == 0 {
+		fmt.Fprintln(w, "no steps were traced")
+		return
+	}
+
+	started, ended := steps[0].Started, steps[0].Ended()
+	for _, step := range steps {
+		if step.Started.Before(started) {
+			started = step.Started
+		}
+		if step.Ended().After(ended) {
+			ended = step.Ended()
+		}
+	}
+	span := ended.Sub(started)
+	if span <= 0 {
+		span = time.Millisecond
+	}
+
+	for _, step := range steps {
+		offset := int(float64(step.Started.Sub(started)) / float64(span) * traceBarWidth)
+		length := int(float64(time.Duration(step.Duration)*time.Millisecond) / float64(span) * traceBarWidth)
+		if length < 1 {
+			length = 1
+		}
+		if offset+length > traceBarWidth {
+			offset = traceBarWidth - length
+		}
+		bar := strings.Repeat(" ", offset) + strings.Repeat("█", length) + strings.Repeat(" ", traceBarWidth-offset-length)
+
+		outcome := color.FgGreen.Render(step.Outcome)
+		switch {
+		case step.Outcome != TraceOutcomeOK:
+			outcome = color.FgRed.Render(step.Outcome)
+			bar = color.FgRed.Render(bar)
+		case step.Attempts > 1:
+			outcome = color.FgYellow.Render(fmt.Sprintf("%s x%d", step.Outcome, step.Attempts))
+			bar = color.FgYellow.Render(bar)
+		}
+
+		context := step.Source
+		if step.Account != "" {
+			context += " " + step.Account
+		}
+
+		fmt.Fprintf(w, "%9s %8s |%s| %-10s %s %s\n",
+			"+"+formatTraceDuration(step.Started.Sub(started)),
+			formatTraceDuration(time.Duration(step.Duration)*time.Millisecond),
+			bar,
+			outcome,
+			color.FgGray.Render(context),
+			strings.TrimSpace(step.Action+" "+step.Target),
+		)
+		if step.Error != "" {
+			fmt.Fprintf(w, "%20s %s\n", "", color.FgRed.Render(step.Error))
+		}
+	}
+}
+
+func formatTraceDuration(duration time.Duration) string {
+	return fmt.Sprintf("%.1fs", duration.Seconds())
+}
+
+// TraceSummary is how one action on one target went across traced steps.
+type TraceSummary struct {
+	Action   string
+	Target   string
+	Count    int
+	Failures int
+	// steps that needed more than one attempt
+	Retried int
+	Total   time.Duration
+	Slowest time.Duration
+}
+
+func (s TraceSummary) Average() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// SummariseTrace groups steps by action and target, slowest in total first.
+func SummariseTrace(steps []TraceStep) []TraceSummary {
+	summaries := map[string]*TraceSummary{}
+	for _, step := range steps {
+		key := step.Action + "\x00" + step.Target
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &TraceSummary{Action: step.Action, Target: step.Target}
+			summaries[key] = summary
+		}
+
+		duration := time.Duration(step.Duration) * time.Millisecond
+		summary.Count++
+		summary.Total += duration
+		if duration > summary.Slowest {
+			summary.Slowest = duration
+		}
+		if step.Outcome != TraceOutcomeOK {
+			summary.Failures++
+		}
+		if step.Attempts > 1 {
+			summary.Retried++
+		}
+	}
+
+	output := []TraceSummary{}
+	for _, summary := range summaries {
+		output = append(output, *summary)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Total != output[j].Total {
+			return output[i].Total > output[j].Total
+		}
+		return output[i].Action+output[i].Target < output[j].Action+output[j].Target
+	})
+	return output
+}
+
+// RenderTraceSummary writes a line per summary, flagging the flaky ones.
+func RenderTraceSummary(w io.Writer, summaries []TraceSummary) {
+	fmt.Fprintf(w, "%6s %8s %8s %8s %8s  %s\n", "count", "failed", "retried", "average", "slowest", "step")
+	for _, summary := range summaries {
+		line := fmt.Sprintf("%6d %8d %8d %8s %8s  %s",
+			summary.Count,
+			summary.Failures,
+			summary.Retried,
+			formatTraceDuration(summary.Average()),
+			formatTraceDuration(summary.Slowest),
+			strings.TrimSpace(summary.Action+" "+summary.Target),
+		)
+		switch {
+		case summary.Failures > 0:
+			line = color.FgRed.Render(line)
+		case summary.Retried > 0:
+			line = color.FgYellow.Render(line)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
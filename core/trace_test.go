@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepTraceRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", TraceFile)
+	trace := NewStepTrace(path)
+
+	started := time.Date(2023, 11, 18, 9, 30, 0, 0, time.UTC)
+	context := TraceContext{Source: "00-anz", Processor: "anz", Account: "everyday"}
+	assert.NoError(t, trace.Record(TraceStep{
+		TraceContext: context,
+		Action:       "goto",
+		Target:       "https://bank.example/login",
+		Started:      started,
+		Duration:     1200,
+		Outcome:      TraceOutcomeOK,
+		Attempts:     1,
+	}))
+	assert.NoError(t, trace.Record(TraceStep{
+		TraceContext: context,
+		Action:       "click",
+		Target:       "#export",
+		Started:      started.Add(1200 * time.Millisecond),
+		Duration:     800,
+		Outcome:      TraceOutcomeFailed,
+		Attempts:     3,
+		Class:        "node-detached",
+		Error:        "could not click: #export: node detached",
+	}))
+	assert.NoError(t, trace.Close())
+
+	steps, err := ReadTrace(path)
+	assert.NoError(t, err)
+	assert.Len(t, steps, 2)
+	assert.Equal(t, context, steps[1].TraceContext)
+	assert.Equal(t, "node-detached", steps[1].Class)
+	assert.True(t, steps[0].Started.Equal(started))
+
+	var output bytes.Buffer
+	RenderTrace(&output, steps)
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "goto https://bank.example/login")
+	assert.Contains(t, lines[1], "+1.2s")
+	assert.Contains(t, lines[2], "node detached")
+}
+
+func TestErrorClass(t *testing.T) {
+	err := NewStepError("click", "#export", ErrNodeDetached, fmt.Errorf("gone"))
+	assert.Equal(t, "node-detached", ErrorClass(err))
+	assert.Equal(t, "", ErrorClass(fmt.Errorf("something else")))
+}
+
+func TestSummariseTraceSlowestFirst(t *testing.T) {
+	summaries := SummariseTrace([]TraceStep{
+		{Action: "find", Target: "#accounts", Duration: 200, Outcome: TraceOutcomeOK, Attempts: 1},
+		{Action: "click", Target: "#export", Duration: 900, Outcome: TraceOutcomeOK, Attempts: 2},
+		{Action: "find", Target: "#accounts", Duration: 400, Outcome: TraceOutcomeFailed, Attempts: 1},
+	})
+
+	assert.Equal(t, []TraceSummary{
+		{Action: "click", Target: "#export", Count: 1, Retried: 1, Total: 900 * time.Millisecond, Slowest: 900 * time.Millisecond},
+		{Action: "find", Target: "#accounts", Count: 2, Failures: 1, Total: 600 * time.Millisecond, Slowest: 400 * time.Millisecond},
+	}, summaries)
+	assert.Equal(t, 300*time.Millisecond, summaries[1].Average())
+}
@@ -2,25 +2,33 @@ package processors
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/airtonix/bank-downloaders/core"
 	"github.com/airtonix/bank-downloaders/store"
-	"github.com/sirupsen/logrus"
 )
 
 type AnzProcessor struct {
 	Credentials store.UsernameAndPassword
 	store.SourceConfig
 	Processor
-	Automation *core.Automation
+	Automation core.IAutomation
+	// the default page objects, with the source's selectors applied
+	PageObjects AnzPageObjects
+	// read once by ListFormats
+	formats []string
 }
 
 // ensure that AnzProcessor implements the Processor interface
 var _ IProcessor = (*AnzProcessor)(nil)
+var _ ISessionProcessor = (*AnzProcessor)(nil)
+var _ IKeystrokeProcessor = (*AnzProcessor)(nil)
+var _ IAccountProcessor = (*AnzProcessor)(nil)
+var _ IFormatProcessor = (*AnzProcessor)(nil)
 
 func (processor *AnzProcessor) Login() error {
-	var err error
 	loginDetails := processor.Credentials
 	url := fmt.Sprintf(
 		"%s/internetbanking",
@@ -29,42 +37,192 @@ func (processor *AnzProcessor) Login() error {
 
 	automation := processor.Automation
 
-	logrus.Info("logging into ", url)
+	automation.Logger().Info("logging into ", url)
 
 	// start at the login page
-	automation.Goto(url)
-	core.AssertErrorToNilf(
-		fmt.Sprintf("could not goto: %s", url),
-		err)
-	automation.SetViewportSize(1200, 900)
+	if err := automation.Goto(url); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	if err := automation.SetViewportSize(1200, 900); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
 
-	logrus.Debugln("waiting for login page to load...")
+	automation.Logger().Debugln("waiting for login page to load...")
 	// wait for the login page to load
-	automation.Find(pageObjects.LoginHeader)
+	if err := automation.Find(processor.PageObjects.LoginHeader); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
 
 	// Username
-	automation.Find(pageObjects.LoginUsernameInput)
-	automation.Focus(pageObjects.LoginUsernameInput)
-	automation.Fill(pageObjects.LoginUsernameInput, loginDetails.Username)
+	if err := automation.Find(processor.PageObjects.LoginUsernameInput); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	if err := automation.Focus(processor.PageObjects.LoginUsernameInput); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	if err := automation.Fill(processor.PageObjects.LoginUsernameInput, loginDetails.Username); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
 
 	// Password
-	automation.Find(pageObjects.LoginPasswordInput)
-	automation.Focus(pageObjects.LoginPasswordInput)
-	automation.FillSensitive(pageObjects.LoginPasswordInput, loginDetails.Password)
+	if err := automation.Find(processor.PageObjects.LoginPasswordInput); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	if err := automation.Focus(processor.PageObjects.LoginPasswordInput); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	if err := automation.FillSensitive(processor.PageObjects.LoginPasswordInput, loginDetails.Password); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
 
 	// LoginButton
-	automation.Click(pageObjects.LoginButton)
+	if err := automation.Click(processor.PageObjects.LoginButton); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
 
-	logrus.Info("authenticating...")
+	automation.Logger().Info("authenticating...")
 
 	// Accounts Page
 	// wait for the account page to load
-	automation.Find(pageObjects.AccountsPageHeader)
-	logrus.Info("authenticated")
+	if err := automation.Find(processor.PageObjects.AccountsPageHeader); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	automation.Logger().Info("authenticated")
+
+	return nil
+}
+
+// the date pickers are react controlled and ignore values set directly
+func (processor *AnzProcessor) KeystrokeFields() []string {
+	return []string{
+		processor.PageObjects.ExportDateRangeFromDateInput,
+		processor.PageObjects.ExportDateRangeToDateInput,
+	}
+}
+
+func (processor *AnzProcessor) ResumeSession() error {
+	url := fmt.Sprintf(
+		"%s/internetbanking",
+		processor.SourceConfig.Domain,
+	)
+
+	automation := processor.Automation
+
+	automation.Logger().Info("resuming session at ", url)
+
+	if err := automation.Goto(url); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+	if err := automation.SetViewportSize(1200, 900); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+
+	// a live session goes straight to the accounts page, an expired one
+	// ends up back at the login page
+	found, err := automation.FindFirst(
+		processor.PageObjects.AccountsPageHeader,
+		processor.PageObjects.LoginHeader,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+	if found != processor.PageObjects.AccountsPageHeader {
+		return core.ErrSessionExpired
+	}
+	automation.Logger().Info("authenticated")
 
 	return nil
 }
 
+// ListAccounts reads each account listed on the accounts page, which
+// Login and ResumeSession leave the browser on.
+func (processor *AnzProcessor) ListAccounts() ([]DiscoveredAccount, error) {
+	automation := processor.Automation
+
+	if err := automation.Find(processor.PageObjects.AccountsPageHeader); err != nil {
+		return nil, err
+	}
+	// unverified against the live site, the wrapper of each account is all
+	// the accounts page is known to have, with the number in its text. The
+	// number is picked out of it and whatever else it shows is the name.
+	entries, err := automation.ReadAll(processor.PageObjects.AccountsListEntry, map[string]string{
+		"text": "",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []DiscoveredAccount{}
+	for _, entry := range entries {
+		text := collapseSpaces(entry["text"])
+		number := strings.TrimSpace(accountNumberPattern.FindString(text))
+		// without a number it can't be downloaded
+		if number == "" {
+			automation.Logger().Warnf("skipping account without a number: %s", text)
+			continue
+		}
+		name := collapseSpaces(strings.Replace(text, number, "", 1))
+		if name == "" {
+			name = number
+		}
+		accounts = append(accounts, DiscoveredAccount{Name: name, Number: number})
+	}
+	automation.Logger().Infof("found %d accounts", len(accounts))
+
+	return accounts, nil
+}
+
+// ListFormats reads the software packages the export page of the first
+// account offers, which are the same for every account.
+func (processor *AnzProcessor) ListFormats() ([]string, error) {
+	if processor.formats != nil {
+		return processor.formats, nil
+	}
+	automation := processor.Automation
+
+	if err := automation.Find(processor.PageObjects.NavigateToHomeButton); err != nil {
+		return nil, err
+	}
+	if err := automation.Click(processor.PageObjects.NavigateToHomeButton); err != nil {
+		return nil, err
+	}
+	if err := automation.Click(processor.PageObjects.FirstAccountsListEntry); err != nil {
+		return nil, err
+	}
+	if err := automation.Find(processor.PageObjects.AccountTransactionTabButton); err != nil {
+		return nil, err
+	}
+	if err := automation.Click(processor.PageObjects.AccountTransactionTabButton); err != nil {
+		return nil, err
+	}
+	if err := automation.Click(processor.PageObjects.AccountGotoExportButton); err != nil {
+		return nil, err
+	}
+	if err := automation.Find(processor.PageObjects.ExportPageHeader); err != nil {
+		return nil, err
+	}
+	if err := automation.Click(processor.PageObjects.ExportDownloadFormatDropdownLabel); err != nil {
+		return nil, err
+	}
+	options, err := automation.ReadAll(processor.PageObjects.ExportDownloadFormatOptions, map[string]string{
+		"format": "",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	formats := []string{}
+	for _, option := range options {
+		if format := collapseSpaces(option["format"]); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	automation.Logger().Infof("found %d formats", len(formats))
+	processor.formats = formats
+
+	return formats, nil
+}
+
 func (processor *AnzProcessor) DownloadTransactions(
 	accountName string,
 	accountNumber string,
@@ -83,7 +241,7 @@ func (processor *AnzProcessor) DownloadTransactions(
 	// As such, when we want to download transactions for an account, we first need to go to the
 	// home page, then click the account button, then click the download button.
 
-	logrus.Infoln(
+	automation.Logger().Infoln(
 		fmt.Sprintf(
 			"Fetching transactions for: %s [%s]: %s - %s",
 			accountName,
@@ -92,51 +250,97 @@ func (processor *AnzProcessor) DownloadTransactions(
 			toDateString,
 		),
 	)
-	automation.Find(pageObjects.NavigateToHomeButton)
-	automation.Click(pageObjects.NavigateToHomeButton)
+
+	// the json the pages fetch has fields the export drops
+	var capture *core.ResponseCapture
+	if processor.SourceConfig.CaptureResponses != "" {
+		var err error
+		capture, err = automation.CaptureResponses(processor.SourceConfig.CaptureResponses)
+		if err != nil {
+			return "", err
+		}
+		defer capture.Stop()
+	}
+
+	if err := automation.Find(processor.PageObjects.NavigateToHomeButton); err != nil {
+		return "", err
+	}
+	if err := automation.Click(processor.PageObjects.NavigateToHomeButton); err != nil {
+		return "", err
+	}
 	// ANZ web app uses responsive design, so we need to set the viewport size
 	// otherwise we get a different set of selectors (we use the desktop version)
-	automation.SetViewportSize(1200, 900)
+	if err := automation.SetViewportSize(1200, 900); err != nil {
+		return "", err
+	}
 
-	automation.Pause(100)
+	if err := automation.Pause(100); err != nil {
+		return "", err
+	}
 
 	// find the account button
-	automation.Click(fmt.Sprintf(pageObjects.AccountsListAccountButton, accountNumber))
+	if err := automation.Click(fmt.Sprintf(processor.PageObjects.AccountsListAccountButton, accountNumber)); err != nil {
+		return "", err
+	}
 
-	automation.Find(fmt.Sprintf(pageObjects.AccountDetailHeader, accountNumber))
-	automation.Find(pageObjects.AccountTransactionTabButton)
+	if err := automation.Find(fmt.Sprintf(processor.PageObjects.AccountDetailHeader, accountNumber)); err != nil {
+		return "", err
+	}
+	if err := automation.Find(processor.PageObjects.AccountTransactionTabButton); err != nil {
+		return "", err
+	}
 	// click the transaction tab button
-	automation.Click(pageObjects.AccountTransactionTabButton)
+	if err := automation.Click(processor.PageObjects.AccountTransactionTabButton); err != nil {
+		return "", err
+	}
 
 	// find the account button
-	automation.Click(pageObjects.AccountGotoExportButton)
+	if err := automation.Click(processor.PageObjects.AccountGotoExportButton); err != nil {
+		return "", err
+	}
 
 	// Transactions Page
 	// wait for the page to load
-	automation.Find(pageObjects.ExportPageHeader)
+	if err := automation.Find(processor.PageObjects.ExportPageHeader); err != nil {
+		return "", err
+	}
 
 	// pick the account by clicking the label "Account"
-	automation.Click(pageObjects.ExportAccountDropdownLabel)
+	if err := automation.Click(processor.PageObjects.ExportAccountDropdownLabel); err != nil {
+		return "", err
+	}
 	// then click the account option
-	automation.Click(fmt.Sprintf(pageObjects.ExportAccountDropdownOption, accountNumber))
-	logrus.Debug("selected account: ", accountNumber)
+	if err := automation.Click(fmt.Sprintf(processor.PageObjects.ExportAccountDropdownOption, accountNumber)); err != nil {
+		return "", err
+	}
+	automation.Logger().Debug("selected account: ", accountNumber)
 
 	// change to date range mode
-	automation.Click(pageObjects.ExportDateRangeModeButton)
+	if err := automation.Click(processor.PageObjects.ExportDateRangeModeButton); err != nil {
+		return "", err
+	}
 	// select the date range fromDate
-	automation.Fill(pageObjects.ExportDateRangeFromDateInput, fromDateString)
+	if err := automation.Fill(processor.PageObjects.ExportDateRangeFromDateInput, fromDateString); err != nil {
+		return "", err
+	}
 	// select the date range toDate
-	automation.Fill(pageObjects.ExportDateRangeToDateInput, toDateString)
-	logrus.Debugf(
+	if err := automation.Fill(processor.PageObjects.ExportDateRangeToDateInput, toDateString); err != nil {
+		return "", err
+	}
+	automation.Logger().Debugf(
 		"selected date range: %s - %s",
 		fromDateString, toDateString,
 	)
 
 	// select the downlaod format by clicking the label "Software package"
-	automation.Click(pageObjects.ExportDownloadFormatDropdownLabel)
+	if err := automation.Click(processor.PageObjects.ExportDownloadFormatDropdownLabel); err != nil {
+		return "", err
+	}
 	// select the download format
-	automation.Click(fmt.Sprintf(pageObjects.ExportDownloadFormatDropdownOption, format))
-	logrus.Debug("selected format: ", format)
+	if err := automation.Click(fmt.Sprintf(processor.PageObjects.ExportDownloadFormatDropdownOption, format)); err != nil {
+		return "", err
+	}
+	automation.Logger().Debug("selected format: ", format)
 
 	filenameContext := store.NewFilenameTemplateContext(
 		processor.Name,
@@ -152,35 +356,95 @@ func (processor *AnzProcessor) DownloadTransactions(
 	filename, err := automation.DownloadFile(
 		filenameTemplate.Render(filenameContext),
 		func() error {
-			return automation.Click(pageObjects.ExportDownloadButton)
+			return automation.Click(processor.PageObjects.ExportDownloadButton)
 		},
+		// every export format is text, an html page means something went wrong
+		"text/plain", "text/xml",
 	)
-	core.AssertErrorToNilf(
-		fmt.Sprintf("could not download file: %s", filename),
-		err)
+	if err != nil {
+		return "", err
+	}
+
+	automation.Logger().Info("Downloaded ", filename)
 
-	logrus.Info("Downloaded", filename)
+	if capture != nil {
+		SaveCapturedResponses(automation, capture, filename)
+	}
 
 	return filename, nil
 }
 
+func init() {
+	Register(ProcessorRegistration{
+		Type:        store.AnzSourceType,
+		Description: "ANZ internet banking, Australia",
+		Credentials: []store.CredentialSourceType{
+			store.CredentialSourceTypeFile,
+			store.CredentialSourceTypeEnv,
+			store.CredentialSourceTypeGopass,
+			store.CredentialSourceTypeKeychain,
+		},
+		// what the site offered when last checked, the site itself decides
+		// since it can list its formats
+		ExportFormats: []string{
+			"Microsoft Money(OFC)",
+			"MYOB(OFX)",
+			"MYOB(QIF)",
+			"Quicken(OFX)",
+			"Quicken(QIF)",
+			"Microsoft Excel(CSV)",
+			"Agrimaster(CSV)",
+			"Phoenix Gateway(CSV)",
+		},
+		Capabilities: []ProcessorCapability{
+			CapabilitySessions,
+			CapabilityKeystrokes,
+			CapabilityCaptureResponses,
+			CapabilityListAccounts,
+			CapabilityListFormats,
+		},
+		Selectors: PageObjectSelectors(pageObjects),
+		Factory: func(config store.SourceConfig, credentials store.Credentials, automation core.IAutomation) (IProcessor, error) {
+			if err := ValidateSelectors(PageObjectSelectors(pageObjects), config.Selectors); err != nil {
+				return nil, err
+			}
+			return NewAnzProcessor(config, credentials.UsernameAndPassword, automation), nil
+		},
+	})
+}
+
 func NewAnzProcessor(
 	config store.SourceConfig,
 	credentials store.UsernameAndPassword,
-	automation *core.Automation,
+	automation core.IAutomation,
 ) *AnzProcessor {
 	processor := Processor{
 		Name: "anz",
 	}
 
+	// each processor gets its own copy, so sources can override differently
+	objects := pageObjects
+	if err := OverridePageObjects(&objects, config.Selectors); err != nil {
+		automation.Logger().Warn(err)
+	}
+
 	return &AnzProcessor{
 		Processor:    processor,
 		SourceConfig: config,
 		Automation:   automation,
 		Credentials:  credentials,
+		PageObjects:  objects,
 	}
 }
 
+// an account number as the accounts page shows it, eg: 012-345 123456789
+var accountNumberPattern = regexp.MustCompile(`\d[\d -]{4,}\d`)
+
+// the text as one line, since the page lays it out over several
+func collapseSpaces(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
 // AnzPageObjects is a struct that contains the page objects for the ANZ internet banking website.
 type AnzPageObjects struct {
 	LoginHeader                        string
@@ -190,6 +454,8 @@ type AnzPageObjects struct {
 	NavigateToHomeButton               string
 	AccountsPageHeader                 string
 	AccountsListAccountButton          string
+	AccountsListEntry                  string
+	FirstAccountsListEntry             string
 	AccountTransactionTabButton        string
 	AccountDetailHeader                string
 	AccountGotoExportButton            string
@@ -201,9 +467,12 @@ type AnzPageObjects struct {
 	ExportDateRangeToDateInput         string
 	ExportDownloadFormatDropdownLabel  string
 	ExportDownloadFormatDropdownOption string
+	ExportDownloadFormatOptions        string
 	ExportDownloadButton               string
 }
 
+// the selectors of the ANZ site, which sources can override with
+// SourceConfig.Selectors
 var pageObjects = AnzPageObjects{
 	LoginHeader:                        "h1#login-header",
 	LoginUsernameInput:                 "input[name='customerRegistrationNumber']",
@@ -212,6 +481,8 @@ var pageObjects = AnzPageObjects{
 	NavigateToHomeButton:               "div[data-test-id='navbar-container'] [role='button'][aria-label='Home']",
 	AccountsPageHeader:                 "h1[id='home-title']",
 	AccountsListAccountButton:          "//div[@id='main-div'] //*[@id='main-details-wrapper'][contains(., '%s')]",
+	AccountsListEntry:                  "//div[@id='main-div'] //*[@id='main-details-wrapper']",
+	FirstAccountsListEntry:             "(//div[@id='main-div'] //*[@id='main-details-wrapper'])[1]",
 	AccountDetailHeader:                "//div[@id='account-overview'][contains(., '%s')]",
 	AccountTransactionTabButton:        "//ul[@role='tablist'][@aria-label='Account Overview'] //li[@role='tab'] //*[contains(., 'Transactions')]",
 	AccountGotoExportButton:            "//div[@id='search-download'] //span[contains(., 'Download')]",
@@ -223,5 +494,6 @@ var pageObjects = AnzPageObjects{
 	ExportDateRangeToDateInput:         "input[id='todate-textfield']",
 	ExportDownloadFormatDropdownLabel:  "//label[@for='drop-down-search-software-dropdown-field'][contains(., 'Software package')]",
 	ExportDownloadFormatDropdownOption: "//ul[@data-test-id='drop-down-search-software-dropdown-results']/li[@role='option'][contains(., '%s')]",
+	ExportDownloadFormatOptions:        "//ul[@data-test-id='drop-down-search-software-dropdown-results']/li[@role='option']",
 	ExportDownloadButton:               "//*[@data-test-id='footer-primary-button_button'][contains(., 'Download')]",
 }
@@ -1,9 +1,11 @@
 package processors
 
 import (
+	"fmt"
 	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path"
 	"testing"
 	"time"
@@ -264,15 +266,31 @@ func MakeConfigurations(url string) (store.SourceConfig, store.UsernameAndPasswo
 	return sourceConfig, credentials
 }
 
+// skips tests that drive a real chrome when there is none, except in ci
+// where they are the only check of what the fake automation can't see
+func requireChrome(t *testing.T) {
+	err := core.EnsureChromeExists()
+	if err == nil {
+		return
+	}
+	if os.Getenv("CI") != "" {
+		t.Fatal(err)
+	}
+	t.Skip(err)
+}
+
 func TestAnzSourceLogin(t *testing.T) {
-	core.EnsureChromeExists()
+	requireChrome(t)
 
 	var err error
 
 	s := MockServer(t)
 	logrus.SetLevel(logrus.DebugLevel)
 
-	automation := core.NewAutomation()
+	automation, err := core.NewAutomation()
+	if !assert.NoError(t, err, "could not start browser") {
+		return
+	}
 
 	sourceConfig, credentials := MakeConfigurations(s.URL)
 
@@ -287,14 +305,17 @@ func TestAnzSourceLogin(t *testing.T) {
 }
 
 func TestAnzSourceDownload(t *testing.T) {
-	core.EnsureChromeExists()
+	requireChrome(t)
 
 	var err error
 
 	s := MockServer(t)
 	defer s.Close()
 
-	automation := core.NewAutomation()
+	automation, err := core.NewAutomation()
+	if !assert.NoError(t, err, "could not start browser") {
+		return
+	}
 
 	sourceConfig, credentials := MakeConfigurations(s.URL)
 
@@ -316,3 +337,176 @@ func TestAnzSourceDownload(t *testing.T) {
 		downloadFilename,
 		"filename")
 }
+
+// a fake anz, where logging in leads to the accounts page
+func FakeAnz() *core.FakeAutomation {
+	automation := core.NewFakeAutomation(
+		pageObjects.LoginHeader,
+		pageObjects.LoginUsernameInput,
+		pageObjects.LoginPasswordInput,
+		pageObjects.LoginButton,
+	)
+	automation.On("click", pageObjects.LoginButton, func(f *core.FakeAutomation) error {
+		f.Hide(pageObjects.LoginHeader).Show(pageObjects.AccountsPageHeader)
+		return nil
+	})
+	return automation
+}
+
+func TestAnzProcessorLoginFillsCredentials(t *testing.T) {
+	automation := FakeAnz()
+	sourceConfig, credentials := MakeConfigurations("https://anz.example")
+
+	source := NewAnzProcessor(sourceConfig, credentials, automation)
+	assert.NoError(t, source.Login())
+
+	assert.True(t, automation.Called("goto", "https://anz.example/internetbanking"))
+	assert.Equal(t, "username", automation.CallsTo("fill", pageObjects.LoginUsernameInput)[0].Value)
+	assert.Equal(t, "password", automation.CallsTo("fill", pageObjects.LoginPasswordInput)[0].Value)
+	assert.True(t, automation.Called("find", pageObjects.AccountsPageHeader))
+}
+
+func TestAnzProcessorLoginFailsWithoutLoginPage(t *testing.T) {
+	automation := core.NewFakeAutomation()
+	sourceConfig, credentials := MakeConfigurations("https://anz.example")
+
+	err := NewAnzProcessor(sourceConfig, credentials, automation).Login()
+	assert.ErrorIs(t, err, core.ErrLoginFailed)
+	assert.ErrorIs(t, err, core.ErrSelectorNotFound)
+	assert.False(t, automation.Called("click", pageObjects.LoginButton))
+}
+
+func TestAnzProcessorResumeSession(t *testing.T) {
+	sourceConfig, credentials := MakeConfigurations("https://anz.example")
+
+	live := core.NewFakeAutomation(pageObjects.AccountsPageHeader)
+	assert.NoError(t, NewAnzProcessor(sourceConfig, credentials, live).ResumeSession())
+
+	expired := core.NewFakeAutomation(pageObjects.LoginHeader)
+	err := NewAnzProcessor(sourceConfig, credentials, expired).ResumeSession()
+	assert.ErrorIs(t, err, core.ErrSessionExpired)
+}
+
+func TestAnzProcessorDownloadPicksAccountDatesAndFormat(t *testing.T) {
+	accountNumber := "123456789"
+	automation := core.NewFakeAutomation(
+		pageObjects.NavigateToHomeButton,
+		fmt.Sprintf(pageObjects.AccountsListAccountButton, accountNumber),
+		fmt.Sprintf(pageObjects.AccountDetailHeader, accountNumber),
+		pageObjects.AccountTransactionTabButton,
+		pageObjects.AccountGotoExportButton,
+		pageObjects.ExportPageHeader,
+		pageObjects.ExportAccountDropdownLabel,
+		fmt.Sprintf(pageObjects.ExportAccountDropdownOption, accountNumber),
+		pageObjects.ExportDateRangeModeButton,
+		pageObjects.ExportDateRangeFromDateInput,
+		pageObjects.ExportDateRangeToDateInput,
+		pageObjects.ExportDownloadFormatDropdownLabel,
+		fmt.Sprintf(pageObjects.ExportDownloadFormatDropdownOption, "Agrimaster(CSV)"),
+		pageObjects.ExportDownloadButton,
+	)
+	sourceConfig, credentials := MakeConfigurations("https://anz.example")
+
+	source := NewAnzProcessor(sourceConfig, credentials, automation)
+	downloaded, err := source.DownloadTransactions(
+		"My Account",
+		accountNumber,
+		time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 10, 31, 0, 0, 0, 0, time.UTC),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "my-account-123456789.csv", path.Base(downloaded))
+
+	assert.Equal(t, "01/10/2023", automation.CallsTo("fill", pageObjects.ExportDateRangeFromDateInput)[0].Value)
+	assert.Equal(t, "31/10/2023", automation.CallsTo("fill", pageObjects.ExportDateRangeToDateInput)[0].Value)
+	assert.True(t, automation.Called("click", fmt.Sprintf(pageObjects.ExportDownloadFormatDropdownOption, "Agrimaster(CSV)")))
+	assert.True(t, automation.Called("click", pageObjects.ExportDownloadButton))
+}
+
+func TestAnzProcessorDownloadFailsForUnknownAccount(t *testing.T) {
+	automation := core.NewFakeAutomation(pageObjects.NavigateToHomeButton)
+	sourceConfig, credentials := MakeConfigurations("https://anz.example")
+
+	_, err := NewAnzProcessor(sourceConfig, credentials, automation).DownloadTransactions(
+		"Missing", "000000000", time.Now(), time.Now(),
+	)
+	assert.ErrorIs(t, err, core.ErrSelectorNotFound)
+	assert.False(t, automation.Called("download", "missing-000000000.csv"))
+}
+
+func TestGetProcessorFactoryUsesKeystrokeFields(t *testing.T) {
+	automation := core.NewFakeAutomation()
+	sourceConfig, _ := MakeConfigurations("https://anz.example")
+
+	_, err := GetProcecssorFactory(store.AnzSourceType, sourceConfig, store.Credentials{}, automation)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		pageObjects.ExportDateRangeFromDateInput,
+		pageObjects.ExportDateRangeToDateInput,
+	}, automation.Keystrokes)
+
+	_, err = GetProcecssorFactory("westpac", sourceConfig, store.Credentials{}, automation)
+	assert.ErrorIs(t, err, core.ErrUnsupportedSource)
+}
+
+func TestAnzProcessorUsesConfiguredSelectors(t *testing.T) {
+	sourceConfig, credentials := MakeConfigurations("http://localhost")
+	sourceConfig.Selectors = map[string]string{"accountspageheader": "h1.accounts"}
+
+	live := core.NewFakeAutomation("h1.accounts")
+	assert.NoError(t, NewAnzProcessor(sourceConfig, credentials, live).ResumeSession())
+	assert.True(t, live.Called("find", "h1.accounts"))
+
+	// other processors keep the defaults
+	assert.Equal(t, "h1[id='home-title']", pageObjects.AccountsPageHeader)
+}
+
+func TestAnzProcessorListAccounts(t *testing.T) {
+	automation := core.NewFakeAutomation(pageObjects.AccountsPageHeader, pageObjects.AccountsListEntry)
+	automation.Entries[pageObjects.AccountsListEntry] = []map[string]string{
+		{"text": "Everyday\n 012-345\n 123456789"},
+		{"text": "987654321"},
+		{"text": "Closed"},
+	}
+	sourceConfig, credentials := MakeConfigurations("http://localhost")
+
+	accounts, err := NewAnzProcessor(sourceConfig, credentials, automation).ListAccounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []DiscoveredAccount{
+		{Name: "Everyday", Number: "012-345 123456789"},
+		{Name: "987654321", Number: "987654321"},
+	}, accounts)
+}
+
+func TestAnzProcessorListFormats(t *testing.T) {
+	firstAccount := pageObjects.FirstAccountsListEntry
+	automation := core.NewFakeAutomation(pageObjects.NavigateToHomeButton)
+	automation.On("click", firstAccount, func(f *core.FakeAutomation) error {
+		f.Show(pageObjects.AccountTransactionTabButton, pageObjects.AccountGotoExportButton)
+		return nil
+	})
+	automation.On("click", pageObjects.AccountGotoExportButton, func(f *core.FakeAutomation) error {
+		f.Show(pageObjects.ExportPageHeader, pageObjects.ExportDownloadFormatDropdownLabel)
+		return nil
+	})
+	automation.On("click", pageObjects.ExportDownloadFormatDropdownLabel, func(f *core.FakeAutomation) error {
+		f.Show(pageObjects.ExportDownloadFormatOptions)
+		return nil
+	})
+	automation.Entries[pageObjects.ExportDownloadFormatOptions] = []map[string]string{
+		{"format": "CSV"},
+		{"format": " Quicken(QIF)\n"},
+	}
+	automation.Show(firstAccount)
+	sourceConfig, credentials := MakeConfigurations("http://localhost")
+	source := NewAnzProcessor(sourceConfig, credentials, automation)
+
+	formats, err := source.ListFormats()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CSV", "Quicken(QIF)"}, formats)
+
+	// read once per processor
+	_, err = source.ListFormats()
+	assert.NoError(t, err)
+	assert.Len(t, automation.CallsTo("read", pageObjects.ExportDownloadFormatOptions), 1)
+}
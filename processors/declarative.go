@@ -0,0 +1,580 @@
+package processors
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+	"gopkg.in/yaml.v3"
+)
+
+// DeclarativeDefinition is a processor described in a yaml file, for banks
+// where downloading is nothing more than going to pages, filling fields in
+// and clicking buttons.
+type DeclarativeDefinition struct {
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	// the kinds of credentials it can log in with, all of them when empty
+	Credentials   []string `yaml:"credentials"`
+	ExportFormats []string `yaml:"exportFormats"`
+	// how dates are typed into the bank's fields, eg: 02/01/2006
+	DateFormat string `yaml:"dateFormat"`
+	// selectors the steps refer to by name, which may use template values,
+	// eg: //a[contains(., '{{.Account.Number}}')]
+	Selectors map[string]string `yaml:"selectors"`
+	// names of the selectors that are typed into key by key
+	Keystrokes []string `yaml:"keystrokes"`
+	// from the bank's home page to logged in
+	Login []DeclarativeStep `yaml:"login"`
+	// checks a restored session is still logged in, sessions are not
+	// resumed without it
+	Resume []DeclarativeStep `yaml:"resume"`
+	// from logged in to one account's transactions downloaded
+	Download []DeclarativeStep `yaml:"download"`
+}
+
+// DeclarativeStep is one action, only one of which is set. Selectors are
+// named, values are templates.
+type DeclarativeStep struct {
+	// a url to go to
+	Goto string `yaml:"goto"`
+	// waits for the selector
+	Find  string `yaml:"find"`
+	Focus string `yaml:"focus"`
+	Click string `yaml:"click"`
+	// fills the selector with Value
+	Fill string `yaml:"fill"`
+	// like Fill, without Value appearing in logs or traces
+	FillSensitive string `yaml:"fillSensitive"`
+	Value         string `yaml:"value"`
+	// milliseconds to wait
+	Pause int `yaml:"pause"`
+	// resizes the page, eg: 1200x900
+	Viewport string `yaml:"viewport"`
+	// waits for any of the selectors, failing unless the first is found
+	Expect []string `yaml:"expect"`
+	// clicks the selector and saves the file it downloads as the source's
+	// outputTemplate
+	Download  string   `yaml:"download"`
+	MimeTypes []string `yaml:"mimeTypes"`
+}
+
+// the values templates in a definition are rendered with
+type declarativeContext struct {
+	Config      store.SourceConfig
+	Credentials declarativeCredentials
+	Account     declarativeAccount
+	// formatted with the definition's DateFormat
+	FromDate string
+	ToDate   string
+	Format   string
+}
+
+// what templates outside fillSensitive values are checked against, since
+// whatever they render ends up in logs and traces
+type declarativePublicContext struct {
+	Config   store.SourceConfig
+	Account  declarativeAccount
+	FromDate string
+	ToDate   string
+	Format   string
+}
+
+// what keystroke selectors are checked against, since they are rendered
+// once before any account is downloaded
+type declarativeKeystrokeContext struct {
+	Config store.SourceConfig
+	Format string
+}
+
+type declarativeCredentials struct {
+	Username string
+	Password string
+	Totp     string
+}
+
+type declarativeAccount struct {
+	Name   string
+	Number string
+}
+
+// the name of the action a step does, and its selector or value
+func (s DeclarativeStep) action() (string, string) {
+	actions := []struct {
+		name  string
+		value string
+		set   bool
+	}{
+		{"goto", s.Goto, s.Goto != ""},
+		{"find", s.Find, s.Find != ""},
+		{"focus", s.Focus, s.Focus != ""},
+		{"click", s.Click, s.Click != ""},
+		{"fill", s.Fill, s.Fill != ""},
+		{"fillSensitive", s.FillSensitive, s.FillSensitive != ""},
+		{"pause", fmt.Sprint(s.Pause), s.Pause > 0},
+		{"viewport", s.Viewport, s.Viewport != ""},
+		{"expect", strings.Join(s.Expect, ", "), len(s.Expect) > 0},
+		{"download", s.Download, s.Download != ""},
+	}
+	name, value, count := "", "", 0
+	for _, action := range actions {
+		if action.set {
+			name, value = action.name, action.value
+			count++
+		}
+	}
+	if count != 1 {
+		return "", ""
+	}
+	return name, value
+}
+
+// the named selectors a step uses
+func (s DeclarativeStep) selectors() []string {
+	for _, selector := range []string{s.Find, s.Focus, s.Click, s.Fill, s.FillSensitive, s.Download} {
+		if selector != "" {
+			return []string{selector}
+		}
+	}
+	return s.Expect
+}
+
+func parseViewport(viewport string) (int64, int64, error) {
+	var width, height int64
+	if _, err := fmt.Sscanf(viewport, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid viewport: %s, expected <width>x<height>", viewport)
+	}
+	return width, height, nil
+}
+
+// checkTemplate renders content with empty values, so that fields that
+// don't exist are found when the definition is loaded rather than midway
+// through logging in
+func checkTemplate(name string, content string, sensitive bool) error {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(io.Discard, declarativeContext{}); err != nil {
+		return err
+	}
+	if sensitive {
+		return nil
+	}
+	if err := tmpl.Execute(io.Discard, declarativePublicContext{}); err != nil {
+		return errors.New("credentials can only be used in fillSensitive values")
+	}
+	return nil
+}
+
+// checkKeystrokeTemplate checks a keystroke selector only uses what is known
+// before any account is downloaded
+func checkKeystrokeTemplate(name string, content string) error {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(io.Discard, declarativeKeystrokeContext{}); err != nil {
+		return errors.New("keystroke selectors are rendered before any account is downloaded, so they can only use .Config and .Format")
+	}
+	return nil
+}
+
+// ParseDeclarativeDefinition reads a definition, rejecting unknown keys.
+func ParseDeclarativeDefinition(content []byte) (*DeclarativeDefinition, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	var definition DeclarativeDefinition
+	if err := decoder.Decode(&definition); err != nil {
+		return nil, err
+	}
+	if err := definition.Validate(); err != nil {
+		return nil, err
+	}
+	return &definition, nil
+}
+
+// Validate checks the steps only use selectors that are defined, and that
+// every template renders, only using credentials where they are kept out of
+// logs.
+func (d *DeclarativeDefinition) Validate() error {
+	errs := []error{}
+	if d.Type == "" {
+		errs = append(errs, errors.New("type is required"))
+	}
+	for _, kind := range d.Credentials {
+		known := false
+		for _, supported := range store.CredentialSourceTypes {
+			known = known || store.CredentialSourceType(kind) == supported
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("unknown credentials type: %s", kind))
+		}
+	}
+	for _, name := range core.SortedKeys(d.Selectors) {
+		if err := checkTemplate(name, d.Selectors[name], false); err != nil {
+			errs = append(errs, fmt.Errorf("selectors.%s: %w", name, err))
+		}
+	}
+	for _, name := range d.Keystrokes {
+		selector, ok := d.Selectors[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("keystrokes: unknown selector %s", name))
+			continue
+		}
+		if err := checkKeystrokeTemplate(name, selector); err != nil {
+			errs = append(errs, fmt.Errorf("keystrokes: %s: %w", name, err))
+		}
+	}
+	if len(d.Login) == 0 {
+		errs = append(errs, errors.New("login needs at least one step"))
+	}
+
+	downloads := 0
+	for _, phase := range []struct {
+		name  string
+		steps []DeclarativeStep
+	}{{"login", d.Login}, {"resume", d.Resume}, {"download", d.Download}} {
+		for index, step := range phase.steps {
+			where := fmt.Sprintf("%s[%d]", phase.name, index)
+			action, value := step.action()
+			if action == "" {
+				errs = append(errs, fmt.Errorf("%s: needs exactly one action", where))
+				continue
+			}
+			if action == "download" {
+				downloads++
+				if phase.name != "download" {
+					errs = append(errs, fmt.Errorf("%s: files can only be downloaded in the download steps", where))
+				}
+			}
+			if action == "viewport" {
+				if _, _, err := parseViewport(value); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", where, err))
+				}
+			}
+			for _, selector := range step.selectors() {
+				if _, ok := d.Selectors[selector]; !ok {
+					errs = append(errs, fmt.Errorf("%s: unknown selector %s", where, selector))
+				}
+			}
+			if err := checkTemplate(where, step.Goto, false); err != nil {
+				errs = append(errs, fmt.Errorf("%s: goto: %w", where, err))
+			}
+			if err := checkTemplate(where, step.Value, action == "fillSensitive"); err != nil {
+				errs = append(errs, fmt.Errorf("%s: value: %w", where, err))
+			}
+		}
+	}
+	if downloads != 1 {
+		errs = append(errs, fmt.Errorf("download needs exactly one download step, found %d", downloads))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid processor %s: %w", d.Type, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Registration describes the processor for the registry.
+func (d *DeclarativeDefinition) Registration() ProcessorRegistration {
+	credentials := []store.CredentialSourceType{}
+	for _, kind := range d.Credentials {
+		credentials = append(credentials, store.CredentialSourceType(kind))
+	}
+	if len(credentials) == 0 {
+		credentials = append(credentials, store.CredentialSourceTypes...)
+	}
+
+	capabilities := []ProcessorCapability{CapabilityCaptureResponses}
+	if len(d.Resume) > 0 {
+		capabilities = append(capabilities, CapabilitySessions)
+	}
+	if len(d.Keystrokes) > 0 {
+		capabilities = append(capabilities, CapabilityKeystrokes)
+	}
+
+	return ProcessorRegistration{
+		Type:          store.SourceType(d.Type),
+		Description:   d.Description,
+		Credentials:   credentials,
+		ExportFormats: d.ExportFormats,
+		Capabilities:  capabilities,
+		Selectors:     d.Selectors,
+		Factory: func(config store.SourceConfig, credentials store.Credentials, automation core.IAutomation) (IProcessor, error) {
+			if err := ValidateSelectors(d.Selectors, config.Selectors); err != nil {
+				return nil, err
+			}
+			return NewDeclarativeProcessor(d, config, credentials.ResolvedCredentials, automation), nil
+		},
+	}
+}
+
+// LoadDeclarativeProcessors registers the processors defined in the yaml
+// files of dir, a dir that doesn't exist has none. A file that can't be
+// loaded is reported without stopping the others from loading.
+func LoadDeclarativeProcessors(dir string) ([]store.SourceType, error) {
+	loaded := []store.SourceType{}
+
+	files := []string{}
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return loaded, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	errs := []error{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		definition, err := ParseDeclarativeDefinition(content)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+		if _, err := Lookup(store.SourceType(definition.Type)); err == nil {
+			errs = append(errs, fmt.Errorf("%s: a processor is already registered for %s", file, definition.Type))
+			continue
+		}
+		Register(definition.Registration())
+		loaded = append(loaded, store.SourceType(definition.Type))
+	}
+	return loaded, errors.Join(errs...)
+}
+
+// DeclarativeProcessor runs the steps of a DeclarativeDefinition.
+type DeclarativeProcessor struct {
+	Processor
+	store.SourceConfig
+	Definition *DeclarativeDefinition
+	// the definition's selectors, with the source's applied
+	Selectors   map[string]string
+	Credentials store.ResolvedCredentials
+	Automation  core.IAutomation
+}
+
+// ensure that DeclarativeProcessor implements the Processor interface
+var _ IProcessor = (*DeclarativeProcessor)(nil)
+var _ ISessionProcessor = (*DeclarativeProcessor)(nil)
+var _ IKeystrokeProcessor = (*DeclarativeProcessor)(nil)
+
+func NewDeclarativeProcessor(
+	definition *DeclarativeDefinition,
+	config store.SourceConfig,
+	credentials store.ResolvedCredentials,
+	automation core.IAutomation,
+) *DeclarativeProcessor {
+	selectors, err := OverrideSelectors(definition.Selectors, config.Selectors)
+	if err != nil {
+		automation.Logger().Warn(err)
+	}
+
+	return &DeclarativeProcessor{
+		Processor:    Processor{Name: definition.Type},
+		SourceConfig: config,
+		Definition:   definition,
+		Selectors:    selectors,
+		Credentials:  credentials,
+		Automation:   automation,
+	}
+}
+
+// the template values that don't depend on the account being downloaded
+func (processor *DeclarativeProcessor) context() declarativeContext {
+	return declarativeContext{
+		Config: processor.SourceConfig,
+		Credentials: declarativeCredentials{
+			Username: processor.Credentials.ResolvedUsername(),
+			Password: processor.Credentials.ResolvedPassword(),
+			Totp:     processor.Credentials.UsernameAndPasswordAndTotp.Totp,
+		},
+		Format: processor.SourceConfig.ExportFormat,
+	}
+}
+
+func render(name string, content string, context declarativeContext) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, context); err != nil {
+		return "", fmt.Errorf("could not render %s: %w", name, err)
+	}
+	return output.String(), nil
+}
+
+// the selector named name
+func (processor *DeclarativeProcessor) selector(name string, context declarativeContext) (string, error) {
+	return render(name, processor.Selectors[name], context)
+}
+
+// runs steps in order, returning the file the download step saved
+func (processor *DeclarativeProcessor) run(steps []DeclarativeStep, context declarativeContext, filename string) (string, error) {
+	automation := processor.Automation
+	downloaded := ""
+
+	for _, step := range steps {
+		action, _ := step.action()
+		selectors := []string{}
+		for _, name := range step.selectors() {
+			selector, err := processor.selector(name, context)
+			if err != nil {
+				return "", err
+			}
+			selectors = append(selectors, selector)
+		}
+		value, err := render(action, step.Value, context)
+		if err != nil {
+			return "", err
+		}
+
+		switch action {
+		case "goto":
+			var url string
+			if url, err = render(action, step.Goto, context); err == nil {
+				err = automation.Goto(url)
+			}
+		case "find":
+			err = automation.Find(selectors[0])
+		case "focus":
+			err = automation.Focus(selectors[0])
+		case "click":
+			err = automation.Click(selectors[0])
+		case "fill":
+			err = automation.Fill(selectors[0], value)
+		case "fillSensitive":
+			err = automation.FillSensitive(selectors[0], value)
+		case "pause":
+			err = automation.Pause(step.Pause)
+		case "viewport":
+			width, height, _ := parseViewport(step.Viewport)
+			err = automation.SetViewportSize(width, height)
+		case "expect":
+			var found string
+			found, err = automation.FindFirst(selectors...)
+			if err == nil && found != selectors[0] {
+				err = core.NewStepError("expect", selectors[0], core.ErrSelectorNotFound, fmt.Errorf("found %s instead", found))
+			}
+		case "download":
+			downloaded, err = automation.DownloadFile(
+				filename,
+				func() error {
+					return automation.Click(selectors[0])
+				},
+				step.MimeTypes...,
+			)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return downloaded, nil
+}
+
+func (processor *DeclarativeProcessor) Login() error {
+	processor.Automation.Logger().Info("logging in")
+	if _, err := processor.run(processor.Definition.Login, processor.context(), ""); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrLoginFailed, err)
+	}
+	processor.Automation.Logger().Info("authenticated")
+	return nil
+}
+
+// ResumeSession runs the resume steps, a definition without any can't
+// resume sessions so it logs in every time.
+func (processor *DeclarativeProcessor) ResumeSession() error {
+	if len(processor.Definition.Resume) == 0 {
+		return core.ErrSessionExpired
+	}
+	if _, err := processor.run(processor.Definition.Resume, processor.context(), ""); err != nil {
+		return fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+	processor.Automation.Logger().Info("authenticated")
+	return nil
+}
+
+func (processor *DeclarativeProcessor) KeystrokeFields() []string {
+	output := []string{}
+	for _, name := range processor.Definition.Keystrokes {
+		selector, err := processor.selector(name, processor.context())
+		if err != nil {
+			processor.Automation.Logger().Warnf("keystrokes: %s", err)
+			continue
+		}
+		output = append(output, selector)
+	}
+	return output
+}
+
+func (processor *DeclarativeProcessor) DownloadTransactions(
+	accountName string,
+	accountNumber string,
+	fromDate time.Time,
+	toDate time.Time,
+) (string, error) {
+	automation := processor.Automation
+	dateFormat := processor.Definition.DateFormat
+	if dateFormat == "" {
+		dateFormat = "02/01/2006"
+	}
+
+	context := processor.context()
+	context.Account = declarativeAccount{Name: accountName, Number: accountNumber}
+	context.FromDate = fromDate.Format(dateFormat)
+	context.ToDate = toDate.Format(dateFormat)
+
+	automation.Logger().Infof(
+		"Fetching transactions for: %s [%s]: %s - %s",
+		accountName,
+		accountNumber,
+		context.FromDate,
+		context.ToDate,
+	)
+
+	var capture *core.ResponseCapture
+	if processor.SourceConfig.CaptureResponses != "" {
+		var err error
+		capture, err = automation.CaptureResponses(processor.SourceConfig.CaptureResponses)
+		if err != nil {
+			return "", err
+		}
+		defer capture.Stop()
+	}
+
+	filenameContext := store.NewFilenameTemplateContext(
+		processor.Name,
+		accountName,
+		accountNumber,
+		fromDate,
+		toDate,
+	)
+	filename := store.NewFilenameTemplate(processor.OutputTemplate).Render(filenameContext)
+
+	downloaded, err := processor.run(processor.Definition.Download, context, filename)
+	if err != nil {
+		return "", err
+	}
+	automation.Logger().Info("Downloaded ", downloaded)
+
+	if capture != nil {
+		SaveCapturedResponses(automation, capture, downloaded)
+	}
+	return downloaded, nil
+}
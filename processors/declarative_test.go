@@ -0,0 +1,213 @@
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/stretchr/testify/assert"
+)
+
+const creditUnionDefinition = `
+type: creditunion
+description: Example Credit Union
+credentials: [file, env]
+exportFormats: [CSV, OFX]
+dateFormat: "2006-01-02"
+selectors:
+  loginHeader: h1#login
+  username: input[name='member']
+  password: input[name='password']
+  loginButton: button[type='submit']
+  accountsHeader: h1#accounts
+  account: "//a[contains(., '{{.Account.Number}}')]"
+  fromDate: input#from
+  toDate: input#to
+  format: "//select[@id='format']/option[.='{{.Format}}']"
+  exportButton: button#export
+keystrokes: [fromDate, toDate]
+login:
+  - goto: "{{.Config.Domain}}/login"
+  - viewport: 1200x900
+  - find: loginHeader
+  - fillSensitive: username
+    value: "{{.Credentials.Username}}"
+  - fillSensitive: password
+    value: "{{.Credentials.Password}}"
+  - click: loginButton
+  - find: accountsHeader
+resume:
+  - goto: "{{.Config.Domain}}/accounts"
+  - expect: [accountsHeader, loginHeader]
+download:
+  - click: account
+  - fill: fromDate
+    value: "{{.FromDate}}"
+  - fill: toDate
+    value: "{{.ToDate}}"
+  - click: format
+  - download: exportButton
+    mimeTypes: [text/plain]
+`
+
+func TestParseDeclarativeDefinition(t *testing.T) {
+	definition, err := ParseDeclarativeDefinition([]byte(creditUnionDefinition))
+	assert.NoError(t, err)
+
+	registration := definition.Registration()
+	assert.Equal(t, store.SourceType("creditunion"), registration.Type)
+	assert.True(t, registration.SupportsCredentials(store.CredentialSourceTypeEnv))
+	assert.False(t, registration.SupportsCredentials(store.CredentialSourceTypeGopass))
+	assert.True(t, registration.HasCapability(CapabilitySessions))
+	assert.True(t, registration.HasCapability(CapabilityKeystrokes))
+}
+
+func TestDeclarativeDefinitionRejectsMistakes(t *testing.T) {
+	_, err := ParseDeclarativeDefinition([]byte("type: x\nlogn: []\n"))
+	assert.ErrorContains(t, err, "field logn not found")
+
+	_, err = ParseDeclarativeDefinition([]byte(`
+type: x
+credentials: [carrier-pigeon]
+selectors:
+  button: button
+login:
+  - click: buton
+  - click: button
+    find: button
+  - viewport: big
+download:
+  - click: button
+`))
+	assert.ErrorContains(t, err, "unknown credentials type: carrier-pigeon")
+	assert.ErrorContains(t, err, "login[0]: unknown selector buton")
+	assert.ErrorContains(t, err, "login[1]: needs exactly one action")
+	assert.ErrorContains(t, err, "login[2]: invalid viewport")
+	assert.ErrorContains(t, err, "download needs exactly one download step")
+
+	_, err = ParseDeclarativeDefinition([]byte(`
+type: x
+selectors:
+  account: "//a[contains(., '{{.Acount.Number}}')]"
+  secret: "//input[@value='{{.Credentials.Password}}']"
+  field: input
+login:
+  - goto: "{{.Config.Domian}}/login"
+  - fill: field
+    value: "{{.Credentials.Password}}"
+  - fillSensitive: field
+    value: "{{.Credentials.Pasword}}"
+  - fillSensitive: field
+    value: "{{.Credentials.Password}}"
+download:
+  - download: account
+`))
+	assert.ErrorContains(t, err, "selectors.account: template: account:1:26: executing \"account\" at <.Acount.Number>")
+	assert.ErrorContains(t, err, "selectors.secret: credentials can only be used in fillSensitive values")
+	assert.ErrorContains(t, err, `login[0]: goto: template: login[0]:1:9: executing "login[0]" at <.Config.Domian>`)
+	assert.ErrorContains(t, err, "login[1]: value: credentials can only be used in fillSensitive values")
+	assert.ErrorContains(t, err, "login[2]: value: template: login[2]")
+	assert.NotContains(t, err.Error(), "login[3]")
+
+	_, err = ParseDeclarativeDefinition([]byte(`
+type: x
+selectors:
+  amount: "//input[@name='{{.Account.Number}}']"
+  format: "//select[@name='{{.Format}}']"
+keystrokes: [amount, format]
+login:
+  - find: format
+download:
+  - download: amount
+`))
+	assert.ErrorContains(t, err, "keystrokes: amount: keystroke selectors are rendered before any account is downloaded")
+	assert.NotContains(t, err.Error(), "keystrokes: format")
+}
+
+func TestDeclarativeProcessorRunsSteps(t *testing.T) {
+	definition, err := ParseDeclarativeDefinition([]byte(creditUnionDefinition))
+	assert.NoError(t, err)
+
+	automation := core.NewFakeAutomation(
+		"h1#login",
+		"input[name='member']",
+		"input[name='password']",
+		"button[type='submit']",
+	)
+	automation.On("click", "button[type='submit']", func(f *core.FakeAutomation) error {
+		f.Show(
+			"h1#accounts",
+			"//a[contains(., '0042')]",
+			"input#from",
+			"input#to",
+			"//select[@id='format']/option[.='OFX']",
+			"button#export",
+		)
+		return nil
+	})
+
+	credentials := store.ResolvedCredentials{
+		UsernameAndPassword: store.UsernameAndPassword{Username: "member", Password: "secret"},
+	}
+	config := store.SourceConfig{
+		Domain:         "https://cu.example",
+		ExportFormat:   "OFX",
+		OutputTemplate: "{{.Source}}-{{.Account.NumberSlug}}.ofx",
+	}
+	processor := NewDeclarativeProcessor(definition, config, credentials, automation)
+
+	assert.NoError(t, processor.Login())
+	assert.True(t, automation.Called("goto", "https://cu.example/login"))
+	assert.True(t, automation.Called("set viewport size", "1200x900"))
+	assert.Equal(t, "secret", automation.CallsTo("fill", "input[name='password']")[0].Value)
+	assert.Equal(t, []string{"input#from", "input#to"}, processor.KeystrokeFields())
+
+	downloaded, err := processor.DownloadTransactions(
+		"Savings",
+		"0042",
+		time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 10, 31, 0, 0, 0, 0, time.UTC),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "creditunion-0042.ofx", downloaded)
+	assert.Equal(t, "2023-10-01", automation.CallsTo("fill", "input#from")[0].Value)
+	assert.True(t, automation.Called("click", "//select[@id='format']/option[.='OFX']"))
+	assert.True(t, automation.Called("click", "button#export"))
+}
+
+func TestDeclarativeProcessorResumeSession(t *testing.T) {
+	definition, err := ParseDeclarativeDefinition([]byte(creditUnionDefinition))
+	assert.NoError(t, err)
+	config := store.SourceConfig{Domain: "https://cu.example"}
+
+	live := core.NewFakeAutomation("h1#accounts")
+	assert.NoError(t, NewDeclarativeProcessor(definition, config, store.ResolvedCredentials{}, live).ResumeSession())
+
+	expired := core.NewFakeAutomation("h1#login")
+	err = NewDeclarativeProcessor(definition, config, store.ResolvedCredentials{}, expired).ResumeSession()
+	assert.ErrorIs(t, err, core.ErrSessionExpired)
+}
+
+func TestLoadDeclarativeProcessors(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte(creditUnionDefinition)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "first.yaml"), content, 0644))
+	// the same type twice is reported, the first one is kept
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "second.yml"), content, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("type: broken\n"), 0644))
+
+	loaded, err := LoadDeclarativeProcessors(dir)
+	assert.Equal(t, []store.SourceType{"creditunion"}, loaded)
+	assert.ErrorContains(t, err, "already registered for creditunion")
+	assert.ErrorContains(t, err, "invalid processor broken")
+
+	_, err = Lookup("creditunion")
+	assert.NoError(t, err)
+
+	loaded, err = LoadDeclarativeProcessors(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Empty(t, loaded)
+}
@@ -1,7 +1,8 @@
 package processors
 
 import (
-	"errors"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/airtonix/bank-downloaders/core"
@@ -21,39 +22,85 @@ type IProcessor interface {
 	) (string, error)
 }
 
+// ISessionProcessor is implemented by processors that can carry on from a
+// restored browser session instead of logging in again.
+type ISessionProcessor interface {
+	// checks the restored session is still logged in, leaving the browser
+	// where Login would have
+	ResumeSession() error
+}
+
+// IKeystrokeProcessor is implemented by processors whose site has fields
+// that only notice values typed into them key by key.
+type IKeystrokeProcessor interface {
+	KeystrokeFields() []string
+}
+
+// DiscoveredAccount is an account a processor found listed after logging
+// in.
+type DiscoveredAccount struct {
+	Name   string
+	Number string
+	// eg: savings, as the bank calls it
+	Type    string
+	Balance string
+}
+
+// IAccountProcessor is implemented by processors that can list the
+// accounts the login has access to.
+type IAccountProcessor interface {
+	// reads the accounts listed once logged in
+	ListAccounts() ([]DiscoveredAccount, error)
+}
+
+// IFormatProcessor is implemented by processors that can read the export
+// formats the site offers.
+type IFormatProcessor interface {
+	// reads the formats offered once logged in
+	ListFormats() ([]string, error)
+}
+
+// GetProcecssorFactory creates the processor registered for processorName.
 func GetProcecssorFactory(
 	processorName store.SourceType,
 	config store.SourceConfig,
 	credentials store.Credentials,
-	automation *core.Automation,
+	automation core.IAutomation,
 ) (IProcessor, error) {
-	var processor IProcessor
-	var err error
+	registration, err := Lookup(processorName)
+	if err != nil {
+		return nil, err
+	}
+
+	processor, err := registration.Factory(config, credentials, automation)
+	if err != nil {
+		return nil, err
+	}
+	if keystrokes, ok := processor.(IKeystrokeProcessor); ok {
+		automation.UseKeystrokes(keystrokes.KeystrokeFields()...)
+	}
+	return processor, nil
+}
+
+// SaveCapturedResponses stops the capture and writes what it collected next
+// to the downloaded file, eg: statement.csv gets statement.responses.json.
+// Failing to do so is logged, the download itself still succeeded.
+func SaveCapturedResponses(automation core.IAutomation, capture *core.ResponseCapture, filename string) {
+	responses, err := capture.Stop()
+	if err != nil {
+		automation.Logger().Warnf("some responses were not captured: %s", err)
+	}
+	if len(responses) == 0 {
+		automation.Logger().Warn("no responses matched captureResponses")
+		return
+	}
 
-	switch processorName {
-	case store.AnzSourceType:
-		processor = NewAnzProcessor(
-			config,
-			credentials.UsernameAndPassword,
-			automation,
-		)
-		if err != nil {
-			return nil, err
-		}
-		return processor, nil
-	// case "commbank":
-	// 	return &CommbankSource{}, nil
-	// case "banksa":
-	// 	return &BankSaSource{}, nil
-	// case "ingorangeau":
-	// 	return &IngOrangeAuSource{}, nil
-	// case "westpac":
-	// 	return &WestpacSource{}, nil
-	// case "nab":
-	// 	return &NABSource{}, nil
-	default:
-		return nil, errors.New("unsupported processor")
+	responsesPath := strings.TrimSuffix(filename, path.Ext(filename)) + ".responses.json"
+	if err := core.SaveResponses(responsesPath, responses); err != nil {
+		automation.Logger().Warnf("could not save responses: %s", err)
+		return
 	}
+	automation.Logger().Infof("Saved %d responses as %s", len(responses), responsesPath)
 }
 
 type Processor struct {
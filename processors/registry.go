@@ -0,0 +1,181 @@
+package processors
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/sirupsen/logrus"
+)
+
+// ProcessorCapability is something a processor does besides logging in and
+// downloading transactions.
+type ProcessorCapability string
+
+const (
+	// resumes a saved browser session, see ISessionProcessor
+	CapabilitySessions ProcessorCapability = "sessions"
+	// types into fields key by key, see IKeystrokeProcessor
+	CapabilityKeystrokes ProcessorCapability = "keystrokes"
+	// captures the responses pages fetch, see SourceConfig.CaptureResponses
+	CapabilityCaptureResponses ProcessorCapability = "capture-responses"
+	// lists the accounts it can download, see IAccountProcessor
+	CapabilityListAccounts ProcessorCapability = "list-accounts"
+	// reads the export formats its site offers, see IFormatProcessor
+	CapabilityListFormats ProcessorCapability = "list-formats"
+)
+
+// ProcessorFactory creates the processor of a source.
+type ProcessorFactory func(
+	config store.SourceConfig,
+	credentials store.Credentials,
+	automation core.IAutomation,
+) (IProcessor, error)
+
+// ProcessorRegistration describes a processor, and how to create one.
+type ProcessorRegistration struct {
+	Type        store.SourceType
+	Description string
+	// the kinds of credentials it can log in with
+	Credentials []store.CredentialSourceType
+	// what SourceConfig.ExportFormat may be
+	ExportFormats []string
+	Capabilities  []ProcessorCapability
+	// the selectors sources can override by name, eg: loginHeader
+	Selectors map[string]string
+	Factory   ProcessorFactory
+}
+
+// HasCapability reports whether the processor does capability.
+func (r ProcessorRegistration) HasCapability(capability ProcessorCapability) bool {
+	for _, registered := range r.Capabilities {
+		if registered == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsCredentials reports whether the processor can log in with
+// credentials of kind.
+func (r ProcessorRegistration) SupportsCredentials(kind store.CredentialSourceType) bool {
+	for _, supported := range r.Credentials {
+		if supported == kind {
+			return true
+		}
+	}
+	return false
+}
+
+var registryMu sync.RWMutex
+var registry = map[store.SourceType]ProcessorRegistration{}
+
+// Register makes a processor available to sources of its type, processors
+// register themselves from an init function.
+func Register(registration ProcessorRegistration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if registration.Type == "" || registration.Factory == nil {
+		panic("processors: Register needs a type and a factory")
+	}
+	if _, exists := registry[registration.Type]; exists {
+		panic(fmt.Sprintf("processors: Register called twice for %s", registration.Type))
+	}
+	registry[registration.Type] = registration
+}
+
+// Lookup finds the processor registered for sourceType.
+func Lookup(sourceType store.SourceType) (ProcessorRegistration, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registration, ok := registry[sourceType]
+	if !ok {
+		return registration, fmt.Errorf("%w: %s, expected one of %s", core.ErrUnsupportedSource, sourceType, strings.Join(registeredTypes(), ", "))
+	}
+	return registration, nil
+}
+
+// Registered lists every processor, sorted by type.
+func Registered() []ProcessorRegistration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	output := []ProcessorRegistration{}
+	for _, name := range registeredTypes() {
+		output = append(output, registry[store.SourceType(name)])
+	}
+	return output
+}
+
+// the registered types, sorted. Callers hold registryMu
+func registeredTypes() []string {
+	output := []string{}
+	for sourceType := range registry {
+		output = append(output, string(sourceType))
+	}
+	sort.Strings(output)
+	return output
+}
+
+// ValidateSource checks a processor is registered for the source, and that
+// it can log in with the source's credentials and export its format. The
+// format is only warned about for processors that can list the formats
+// their site offers.
+func ValidateSource(source store.Source) error {
+	if err := ValidateSourceLogin(source); err != nil {
+		return err
+	}
+	registration, err := Lookup(source.Type)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateExportFormat(source, registration.ExportFormats)
+	if err != nil && registration.HasCapability(CapabilityListFormats) {
+		// the registered formats may be out of date, the site is asked later
+		logrus.Warnf("%s, checking with the bank's site instead", err)
+		return nil
+	}
+	return err
+}
+
+// ValidateSourceLogin checks a processor is registered for the source, and
+// that it can log in with the source's credentials and selectors, whatever
+// format the source exports.
+func ValidateSourceLogin(source store.Source) error {
+	registration, err := Lookup(source.Type)
+	if err != nil {
+		return err
+	}
+
+	kind, err := store.GetCredentialsType(source.Config.Credentials)
+	if err != nil {
+		return err
+	}
+	if !registration.SupportsCredentials(kind) {
+		return fmt.Errorf("%w: %s can not log in with %s credentials", core.ErrCredentialsUnresolved, source.Type, kind)
+	}
+
+	return ValidateSelectors(registration.Selectors, source.Config.Selectors)
+}
+
+// ValidateExportFormat checks the source's export format is one of
+// formats, eg: those read from the bank's site. Any format will do when
+// formats is empty.
+func ValidateExportFormat(source store.Source, formats []string) error {
+	format := source.Config.ExportFormat
+	if format == "" || len(formats) == 0 {
+		return nil
+	}
+	for _, known := range formats {
+		if known == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s can not export %s, expected one of %s", core.ErrUnsupportedFormat, source.Type, format, strings.Join(formats, ", "))
+}
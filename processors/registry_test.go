@@ -0,0 +1,84 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupRegisteredProcessor(t *testing.T) {
+	registration, err := Lookup(store.AnzSourceType)
+	assert.NoError(t, err)
+	assert.Equal(t, store.AnzSourceType, registration.Type)
+	assert.Contains(t, registration.ExportFormats, "Agrimaster(CSV)")
+
+	_, err = Lookup("westpac")
+	assert.ErrorIs(t, err, core.ErrUnsupportedSource)
+	assert.ErrorContains(t, err, "expected one of anz")
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	registration, err := Lookup(store.AnzSourceType)
+	assert.NoError(t, err)
+	assert.Panics(t, func() { Register(registration) })
+}
+
+func TestAnzCapabilitiesMatchInterfaces(t *testing.T) {
+	registration, err := Lookup(store.AnzSourceType)
+	assert.NoError(t, err)
+	processor, err := registration.Factory(store.SourceConfig{}, store.Credentials{}, core.NewFakeAutomation())
+	assert.NoError(t, err)
+
+	_, sessions := processor.(ISessionProcessor)
+	assert.Equal(t, sessions, registration.HasCapability(CapabilitySessions))
+	_, keystrokes := processor.(IKeystrokeProcessor)
+	assert.Equal(t, keystrokes, registration.HasCapability(CapabilityKeystrokes))
+	_, accounts := processor.(IAccountProcessor)
+	assert.Equal(t, accounts, registration.HasCapability(CapabilityListAccounts))
+	_, formats := processor.(IFormatProcessor)
+	assert.Equal(t, formats, registration.HasCapability(CapabilityListFormats))
+}
+
+func TestValidateSource(t *testing.T) {
+	source := func(credentials string, format string) store.Source {
+		return store.Source{
+			Type: store.AnzSourceType,
+			Config: store.SourceConfig{
+				ExportFormat: format,
+				Credentials:  map[string]interface{}{"type": credentials},
+			},
+		}
+	}
+
+	assert.NoError(t, ValidateSource(source("env", "Quicken(QIF)")))
+	assert.NoError(t, ValidateSource(source("file", "")))
+
+	err := ValidateSource(source("gopass-totp", "CSV"))
+	assert.ErrorIs(t, err, core.ErrCredentialsUnresolved)
+
+	// anz lists its formats, so the registered ones are only advisory
+	assert.NoError(t, ValidateSource(source("file", "Lotus 1-2-3")))
+	assert.NoError(t, ValidateSource(source("file", "Phoenix Gateway(CSV)")))
+
+	registration, err := Lookup(store.AnzSourceType)
+	assert.NoError(t, err)
+	registration.Type = "anz-without-formats"
+	registration.Capabilities = []ProcessorCapability{CapabilitySessions}
+	Register(registration)
+	fixed := source("file", "Lotus 1-2-3")
+	fixed.Type = registration.Type
+	assert.ErrorIs(t, ValidateSource(fixed), core.ErrUnsupportedFormat)
+	// logging in doesn't depend on the format
+	assert.NoError(t, ValidateSourceLogin(fixed))
+
+	// formats read from the site win over the registered ones
+	live := []string{"CSV", "Xero(CSV)"}
+	assert.NoError(t, ValidateExportFormat(source("file", "Xero(CSV)"), live))
+	assert.ErrorIs(t, ValidateExportFormat(source("file", "Quicken(QIF)"), live), core.ErrUnsupportedFormat)
+
+	unknown := source("file", "CSV")
+	unknown.Type = "westpac"
+	assert.ErrorIs(t, ValidateSource(unknown), core.ErrUnsupportedSource)
+}
@@ -0,0 +1,122 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/store"
+)
+
+// the name a page object field is configured by, eg: LoginHeader is
+// loginHeader
+func selectorName(field string) string {
+	first, size := utf8.DecodeRuneInString(field)
+	return string(unicode.ToLower(first)) + field[size:]
+}
+
+// PageObjectSelectors lists the selectors of a page objects struct by name,
+// eg: loginHeader.
+func PageObjectSelectors(pageObjects interface{}) map[string]string {
+	output := map[string]string{}
+	value := reflect.Indirect(reflect.ValueOf(pageObjects))
+	for index := 0; index < value.NumField(); index++ {
+		field := value.Type().Field(index)
+		if field.IsExported() && field.Type.Kind() == reflect.String {
+			output[selectorName(field.Name)] = value.Field(index).String()
+		}
+	}
+	return output
+}
+
+// finds name among the names of defaults, ignoring case since the config
+// reader lowercases keys
+func canonicalSelectorName(defaults map[string]string, name string) (string, bool) {
+	for known := range defaults {
+		if strings.EqualFold(known, name) {
+			return known, true
+		}
+	}
+	return "", false
+}
+
+// ValidateSelectors checks overrides only names selectors in defaults, and
+// that each keeps the placeholders its default is formatted with, eg: the
+// %s an account number goes in.
+func ValidateSelectors(defaults map[string]string, overrides map[string]string) error {
+	errs := []error{}
+	for _, name := range core.SortedKeys(overrides) {
+		known, ok := canonicalSelectorName(defaults, name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown selector: %s, expected one of %s", name, strings.Join(core.SortedKeys(defaults), ", ")))
+			continue
+		}
+		if overrides[name] == "" {
+			errs = append(errs, fmt.Errorf("selector %s is empty", known))
+			continue
+		}
+		expected := strings.Count(defaults[known], "%s")
+		if found := strings.Count(overrides[name], "%s"); found != expected {
+			errs = append(errs, fmt.Errorf("selector %s needs %d %%s placeholders, found %d", known, expected, found))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("selectors: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// ValidateSourcesSelectors checks the selector overrides of every source, so
+// that a mistake in one is found when the config is loaded rather than
+// when its source is downloaded. Sources of unknown types are left to
+// ValidateSource.
+func ValidateSourcesSelectors(sources []store.Source) error {
+	errs := []error{}
+	for index, source := range sources {
+		registration, err := Lookup(source.Type)
+		if err != nil {
+			continue
+		}
+		if err := ValidateSelectors(registration.Selectors, source.Config.Selectors); err != nil {
+			errs = append(errs, fmt.Errorf("source %02d-%s: %w", index, source.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OverrideSelectors returns defaults with the selectors named in overrides
+// replaced. Overrides that aren't valid are left out and reported.
+func OverrideSelectors(defaults map[string]string, overrides map[string]string) (map[string]string, error) {
+	output := map[string]string{}
+	for name, selector := range defaults {
+		output[name] = selector
+	}
+	err := ValidateSelectors(defaults, overrides)
+	for name, selector := range overrides {
+		known, ok := canonicalSelectorName(defaults, name)
+		if !ok || ValidateSelectors(defaults, map[string]string{name: selector}) != nil {
+			continue
+		}
+		output[known] = selector
+	}
+	return output, err
+}
+
+// OverridePageObjects replaces the selectors of the page objects struct
+// pageObjects points to that are named in overrides.
+func OverridePageObjects(pageObjects interface{}, overrides map[string]string) error {
+	selectors, err := OverrideSelectors(PageObjectSelectors(pageObjects), overrides)
+
+	value := reflect.ValueOf(pageObjects).Elem()
+	for index := 0; index < value.NumField(); index++ {
+		field := value.Type().Field(index)
+		if selector, ok := selectors[selectorName(field.Name)]; ok && field.Type.Kind() == reflect.String {
+			value.Field(index).SetString(selector)
+		}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/airtonix/bank-downloaders/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageObjectSelectors(t *testing.T) {
+	selectors := PageObjectSelectors(pageObjects)
+	assert.Equal(t, pageObjects.LoginHeader, selectors["loginHeader"])
+	assert.Equal(t, pageObjects.AccountsListAccountButton, selectors["accountsListAccountButton"])
+}
+
+func TestValidateSelectors(t *testing.T) {
+	defaults := PageObjectSelectors(pageObjects)
+
+	assert.NoError(t, ValidateSelectors(defaults, map[string]string{
+		// the config reader lowercases keys
+		"loginheader":               "h1.login",
+		"accountsListAccountButton": "//a[contains(., '%s')]",
+	}))
+
+	err := ValidateSelectors(defaults, map[string]string{
+		"loginHeadr":                "h1.login",
+		"loginButton":               "",
+		"accountsListAccountButton": "//a[@class='account']",
+	})
+	assert.ErrorContains(t, err, "unknown selector: loginHeadr, expected one of")
+	assert.ErrorContains(t, err, "selector loginButton is empty")
+	assert.ErrorContains(t, err, "selector accountsListAccountButton needs 1 %s placeholders, found 0")
+
+	assert.ErrorContains(t, ValidateSelectors(nil, map[string]string{"button": "b"}), "unknown selector: button")
+}
+
+func TestValidateSourcesSelectors(t *testing.T) {
+	sources := []store.Source{
+		{Type: store.AnzSourceType, Config: store.SourceConfig{Selectors: map[string]string{"loginheader": "h1.login"}}},
+		{Type: store.AnzSourceType, Config: store.SourceConfig{Selectors: map[string]string{"loginHeadr": "h1.login"}}},
+		// reported when the source is checked
+		{Type: "carrier-pigeon", Config: store.SourceConfig{Selectors: map[string]string{"x": "y"}}},
+	}
+
+	err := ValidateSourcesSelectors(sources)
+	assert.ErrorContains(t, err, "source 01-anz: selectors: unknown selector: loginHeadr")
+	assert.NotContains(t, err.Error(), "00-anz")
+	assert.NotContains(t, err.Error(), "carrier-pigeon")
+	assert.NoError(t, ValidateSourcesSelectors(sources[:1]))
+}
+
+func TestOverridePageObjects(t *testing.T) {
+	objects := pageObjects
+	err := OverridePageObjects(&objects, map[string]string{
+		"loginheader": "h1.login",
+		"unknown":     "h1",
+	})
+	assert.ErrorContains(t, err, "unknown selector: unknown")
+	assert.Equal(t, "h1.login", objects.LoginHeader)
+	assert.Equal(t, pageObjects.LoginButton, objects.LoginButton)
+	assert.NotEqual(t, "h1.login", pageObjects.LoginHeader)
+}
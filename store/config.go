@@ -1,9 +1,11 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/airtonix/bank-downloaders/core"
@@ -24,6 +26,72 @@ type SourceConfig struct {
 	OutputTemplate string
 	DaysToFetch    int
 	Credentials    map[string]interface{}
+	// keep the browser logged in between runs
+	PersistSession bool `mapstructure:"persistSession"`
+	// url pattern of responses saved next to each download, * matches anything
+	CaptureResponses string `mapstructure:"captureResponses"`
+	// requests the browser is not allowed to make
+	Block BlockConfig
+	// what happens when a script on the page throws
+	Exceptions ExceptionsConfig
+	// IANA timezone the bank works in, eg: Australia/Adelaide
+	Timezone string
+	// language tag the bank pages are shown in, eg: en-AU
+	Locale string
+	// where the browser reports it is
+	Geolocation *GeolocationConfig
+	// the proxy the browser reaches the bank through
+	Proxy *ProxyConfig
+	// presented to the bank hosts that ask for one
+	ClientCertificates []ClientCertificateConfig `mapstructure:"clientCertificates"`
+	// replaces the processor's selectors by name, eg: loginHeader
+	Selectors map[string]string
+}
+
+// ProxyConfig is the http or socks5 proxy a source is downloaded through.
+type ProxyConfig struct {
+	// eg: http://proxy.example:3128 or socks5://127.0.0.1:1080
+	URL string `mapstructure:"url"`
+	// resolved like the source credentials, none when empty
+	Credentials map[string]interface{}
+	// host patterns reached directly, * matches anything
+	Bypass []string
+}
+
+// ClientCertificateConfig is a pem certificate and key for mutual tls.
+type ClientCertificateConfig struct {
+	// host patterns, * matches anything
+	Hosts []string
+	Cert  string
+	Key   string
+	// extra authorities trusted for those hosts
+	CA string `mapstructure:"ca"`
+}
+
+type GeolocationConfig struct {
+	Latitude  float64
+	Longitude float64
+	Accuracy  float64
+}
+
+// ExceptionsConfig is the policy for exceptions thrown on a source's pages.
+type ExceptionsConfig struct {
+	// ignore, log, report or fail
+	Action string
+	// patterns of exception text or script urls that are ignored
+	Allow []string
+	// patterns of exception text or script urls that are acted on
+	Deny []string
+}
+
+// BlockConfig lists the requests blocked while downloading a source.
+type BlockConfig struct {
+	// also block well known trackers and ads, unless false
+	Defaults *bool
+	// url patterns, * matches anything
+	URLs []string `mapstructure:"urls"`
+	// eg: image, font, media
+	ResourceTypes []string `mapstructure:"resourceTypes"`
 }
 
 type SourceType string
@@ -38,9 +106,76 @@ type Source struct {
 	Config   SourceConfig
 }
 
+// BrowserConfig describes how the browser used for automation is launched.
+type BrowserConfig struct {
+	Headless     bool
+	ExecPath     string
+	UserDataDir  string
+	WindowWidth  int
+	WindowHeight int
+	UserAgent    string
+	Flags        map[string]interface{}
+	// milliseconds to wait before each automation action
+	SlowMotion int
+	// DevTools url of an already running browser, when set no browser is launched
+	RemoteURL string `mapstructure:"remoteUrl"`
+	Input     InputConfig
+}
+
+// InputConfig describes how values are filled into fields, durations are
+// in milliseconds.
+type InputConfig struct {
+	// set-value or keystrokes
+	Mode string
+	// selectors that are always typed into
+	Keystrokes []string
+	KeyDelay   int `mapstructure:"keyDelay"`
+	Jitter     int
+}
+
+// TimeoutsConfig bounds how long parts of a run may take, in milliseconds.
+// Zero keeps the default.
+type TimeoutsConfig struct {
+	Find     int
+	Click    int
+	Navigate int
+	Download int
+	Login    int
+	Account  int
+	Run      int
+}
+
+// RetryPolicyConfig says how a failing step is retried, durations are in
+// milliseconds.
+type RetryPolicyConfig struct {
+	Attempts   int
+	Backoff    int
+	Multiplier float64
+	MaxBackoff int `mapstructure:"maxBackoff"`
+	// names of the error classes to retry, every error when empty
+	RetryOn []string `mapstructure:"retryOn"`
+}
+
+// RetriesConfig holds the retry policy of each browser action and of each
+// account download. An omitted policy keeps the default.
+type RetriesConfig struct {
+	Action  *RetryPolicyConfig
+	Account *RetryPolicyConfig
+}
+
 type Configuration struct {
-	DateFormat string   `mapstructure:"dateformat"`
-	Sources    []Source `mapstructure:"sources"`
+	DateFormat string         `mapstructure:"dateformat"`
+	Browser    BrowserConfig  `mapstructure:"browser"`
+	Timeouts   TimeoutsConfig `mapstructure:"timeouts"`
+	Retries    RetriesConfig  `mapstructure:"retries"`
+	// where each run stores failure screenshots and other recordings
+	ArtifactsDir string `mapstructure:"artifactsDir"`
+	// where persisted sessions are kept, defaults to the user cache directory
+	SessionsDir string `mapstructure:"sessionsDir"`
+	// where processors defined in yaml are loaded from, defaults to the
+	// processors directory next to the config file
+	ProcessorsDir string   `mapstructure:"processorsDir"`
+	Sources       []Source `mapstructure:"sources"`
 }
 
 var conf Configuration
@@ -51,6 +186,85 @@ func GetConfig() *Configuration {
 
 var configReader *viper.Viper
 
+// GetProcessorsDir is where processors defined in yaml are loaded from.
+func GetProcessorsDir() string {
+	if conf.ProcessorsDir != "" {
+		return conf.ProcessorsDir
+	}
+	if configReader != nil && configReader.ConfigFileUsed() != "" {
+		return filepath.Join(filepath.Dir(configReader.ConfigFileUsed()), "processors")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "processors"
+	}
+	return filepath.Join(home, ".config", appname, "processors")
+}
+
+// the digits of an account number, so that 012-345 6789 matches 0123456789
+func accountDigits(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, number)
+}
+
+// MergeAccounts adds the discovered accounts whose numbers aren't in
+// accounts yet, returning the merged accounts and those that were added.
+func MergeAccounts(accounts []Account, discovered []Account) ([]Account, []Account) {
+	known := map[string]bool{}
+	for _, account := range accounts {
+		known[accountDigits(account.Number)] = true
+	}
+
+	merged := append([]Account{}, accounts...)
+	added := []Account{}
+	for _, account := range discovered {
+		if known[accountDigits(account.Number)] {
+			continue
+		}
+		known[accountDigits(account.Number)] = true
+		merged = append(merged, account)
+		added = append(added, account)
+	}
+	return merged, added
+}
+
+// SaveSourceAccounts replaces the accounts of the source at index, in the
+// loaded config and in the config file it was read from. Only the accounts
+// change, the rest of the file keeps the values the user wrote.
+func SaveSourceAccounts(index int, accounts []Account) error {
+	if configReader == nil || configReader.ConfigFileUsed() == "" {
+		return fmt.Errorf("no config file to save accounts to")
+	}
+	file := configReader.ConfigFileUsed()
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("could not save %s: %w", file, err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not save %s: %w", file, err)
+	}
+
+	patched, err := patchSourceAccounts(content, filepath.Ext(file), index, accounts)
+	if errors.Is(err, errNoSource) {
+		return fmt.Errorf("no source %d in %s", index, file)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save %s: %w", file, err)
+	}
+	if err := os.WriteFile(file, patched, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("could not save %s: %w", file, err)
+	}
+	if index < len(conf.Sources) {
+		conf.Sources[index].Accounts = accounts
+	}
+	return nil
+}
+
 func NewConfigReader(configFileArg string) *viper.Viper {
 	configReader = viper.New()
 
@@ -79,6 +293,8 @@ func NewConfigReader(configFileArg string) *viper.Viper {
 	configReader.AddConfigPath(fmt.Sprintf("/etc/%s/", appname))         // path to look for the config file in
 
 	configReader.SetDefault("$schema", "https://raw.githubusercontent.com/airtonix/bankdownloader/master/store/config-schema.json")
+	configReader.SetDefault("browser.headless", true)
+	configReader.SetDefault("browser.slowMotion", 100)
 
 	if err := configReader.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -116,7 +332,8 @@ func CreateNewConfigFile() {
 func InitConfig(configFileArg string) {
 	configReader = NewConfigReader(configFileArg)
 	err := configReader.Unmarshal(&conf)
-
-	core.AssertErrorToNilf("could not unmarshal config: %w", err)
+	if core.AssertErrorToNilf("could not unmarshal config: %w", err) {
+		logrus.Fatal(err)
+	}
 	logrus.Debugln("config file", configReader.ConfigFileUsed())
 }
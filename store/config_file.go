@@ -0,0 +1,152 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// an account as it is written to the config file
+type savedAccount struct {
+	Name           string `json:"name" yaml:"name"`
+	Number         string `json:"number" yaml:"number"`
+	OutputTemplate string `json:"outputTemplate,omitempty" yaml:"outputTemplate,omitempty"`
+	Format         string `json:"format,omitempty" yaml:"format,omitempty"`
+}
+
+func savedAccounts(accounts []Account) []savedAccount {
+	output := []savedAccount{}
+	for _, account := range accounts {
+		output = append(output, savedAccount(account))
+	}
+	return output
+}
+
+var errNoSource = errors.New("no such source")
+
+// patchSourceAccounts returns the content of a config file with the
+// accounts of the source at index replaced, leaving the keys viper would
+// lowercase and the values it would fill in as they were written.
+func patchSourceAccounts(content []byte, ext string, index int, accounts []Account) ([]byte, error) {
+	switch strings.ToLower(ext) {
+	case ".json":
+		return patchJSONSourceAccounts(content, index, accounts)
+	case ".yaml", ".yml":
+		return patchYAMLSourceAccounts(content, index, accounts)
+	}
+	return nil, fmt.Errorf("accounts can only be saved to json or yaml config files, not %s", ext)
+}
+
+// the key of object named like key regardless of its case, or key itself
+func jsonKey(object map[string]json.RawMessage, key string) string {
+	for name := range object {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return key
+}
+
+// json objects are re-encoded with their keys sorted, values that aren't
+// touched are kept as they were written
+func patchJSONSourceAccounts(content []byte, index int, accounts []Account) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	sourcesKey := jsonKey(config, "sources")
+	var sources []map[string]json.RawMessage
+	if raw, ok := config[sourcesKey]; ok {
+		if err := json.Unmarshal(raw, &sources); err != nil {
+			return nil, fmt.Errorf("sources: %w", err)
+		}
+	}
+	if index < 0 || index >= len(sources) {
+		return nil, errNoSource
+	}
+
+	saved, err := marshalJSON(savedAccounts(accounts), "")
+	if err != nil {
+		return nil, err
+	}
+	sources[index][jsonKey(sources[index], "accounts")] = saved
+	if config[sourcesKey], err = marshalJSON(sources, ""); err != nil {
+		return nil, err
+	}
+
+	output, err := marshalJSON(config, "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(output, '\n'), nil
+}
+
+// like json.Marshal, without escaping the html characters that templates
+// and account names may have
+func marshalJSON(v any, indent string) ([]byte, error) {
+	var output bytes.Buffer
+	encoder := json.NewEncoder(&output)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(output.Bytes(), "\n"), nil
+}
+
+// the value of key in a yaml mapping, regardless of its case
+func yamlMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, key) {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func patchYAMLSourceAccounts(content []byte, index int, accounts []Account) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, errNoSource
+	}
+	sources := yamlMappingValue(document.Content[0], "sources")
+	if sources == nil || sources.Kind != yaml.SequenceNode || index < 0 || index >= len(sources.Content) {
+		return nil, errNoSource
+	}
+	source := sources.Content[index]
+	if source.Kind != yaml.MappingNode {
+		return nil, errors.New("source is not an object")
+	}
+
+	var value yaml.Node
+	if err := value.Encode(savedAccounts(accounts)); err != nil {
+		return nil, err
+	}
+	if existing := yamlMappingValue(source, "accounts"); existing != nil {
+		*existing = value
+	} else {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "accounts"}
+		source.Content = append(source.Content, key, &value)
+	}
+
+	var output bytes.Buffer
+	encoder := yaml.NewEncoder(&output)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,3 +26,130 @@ func TestRegisterHistorySchema(t *testing.T) {
 	err := compiler.RegisterHistorySchema()
 	assert.Nil(t, err)
 }
+
+func TestMergeAccounts(t *testing.T) {
+	accounts := []Account{{Name: "Everyday", Number: "012-345 123456789"}}
+	merged, added := MergeAccounts(accounts, []Account{
+		{Name: "Everyday", Number: "012345123456789"},
+		{Name: "Savings", Number: "987654321"},
+	})
+	assert.Equal(t, []Account{{Name: "Savings", Number: "987654321"}}, added)
+	assert.Len(t, merged, 2)
+	assert.Len(t, accounts, 1)
+}
+
+func TestSaveSourceAccounts(t *testing.T) {
+	// the config is looked for relative to the working directory
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	path := "config.json"
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+		"sources": [
+			{"type": "anz", "config": {"domain": "https://x"}, "accounts": []}
+		]
+	}`), 0644))
+	InitConfig(path)
+
+	assert.NoError(t, SaveSourceAccounts(0, []Account{{Name: "Savings", Number: "987654321"}}))
+	assert.Error(t, SaveSourceAccounts(1, nil))
+
+	InitConfig(path)
+	if !assert.Len(t, GetConfig().Sources, 1) {
+		return
+	}
+	assert.Equal(t, []Account{{Name: "Savings", Number: "987654321"}}, GetConfig().Sources[0].Accounts)
+	assert.Equal(t, "https://x", GetConfig().Sources[0].Config.Domain)
+}
+
+func TestSaveSourceAccountsOnlyWritesAccounts(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+	t.Setenv("BANKDOWNLOADER_BROWSER_HEADLESS", "false")
+
+	path := "config.json"
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+    "sources": [
+        {
+            "type": "anz",
+            "config": {"domain": "https://x", "exportFormat": "CSV", "outputTemplate": "{{.Source}}.csv"}
+        },
+        {"type": "anz", "accounts": []}
+    ],
+    "browser": {"execPath": "/usr/bin/chromium"}
+}
+`), 0600))
+	InitConfig(path)
+
+	accounts := []Account{{Name: "Savings & Bills", Number: "0042"}}
+	assert.NoError(t, SaveSourceAccounts(0, accounts))
+
+	// the keys keep their case, and defaults and the environment stay out
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "browser": {
+    "execPath": "/usr/bin/chromium"
+  },
+  "sources": [
+    {
+      "accounts": [
+        {
+          "name": "Savings & Bills",
+          "number": "0042"
+        }
+      ],
+      "config": {
+        "domain": "https://x",
+        "exportFormat": "CSV",
+        "outputTemplate": "{{.Source}}.csv"
+      },
+      "type": "anz"
+    },
+    {
+      "accounts": [],
+      "type": "anz"
+    }
+  ]
+}
+`, string(after))
+
+	InitConfig(path)
+	assert.Equal(t, accounts, GetConfig().Sources[0].Accounts)
+	assert.Equal(t, "CSV", GetConfig().Sources[0].Config.ExportFormat)
+}
+
+func TestSaveSourceAccountsToYAML(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	path := "config.yaml"
+	assert.NoError(t, os.WriteFile(path, []byte(`# downloaded every morning
+sources:
+  - type: anz
+    config:
+      domain: https://x
+      exportFormat: CSV # what the accountant wants
+    accounts: []
+`), 0600))
+	InitConfig(path)
+
+	assert.NoError(t, SaveSourceAccounts(0, []Account{{Name: "Savings", Number: "0042"}}))
+	assert.Error(t, SaveSourceAccounts(1, nil))
+
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `# downloaded every morning
+sources:
+  - type: anz
+    config:
+      domain: https://x
+      exportFormat: CSV # what the accountant wants
+    accounts:
+      - name: Savings
+        number: "0042"
+`, string(after))
+}
@@ -3,8 +3,10 @@ package store
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/airtonix/bank-downloaders/core"
 	"github.com/airtonix/bank-downloaders/store/clients"
 )
 
@@ -38,6 +40,15 @@ const (
 	CredentialSourceTypeKeychainTotp CredentialSourceType = "libsecret-totp"
 )
 
+// CredentialSourceTypes are the kinds of credentials NewCredentials resolves.
+var CredentialSourceTypes = []CredentialSourceType{
+	CredentialSourceTypeFile,
+	CredentialSourceTypeEnv,
+	CredentialSourceTypeGopass,
+	CredentialSourceTypeGopassTotp,
+	CredentialSourceTypeKeychain,
+}
+
 // CredentialsFile is a struct that contains the credentials for a source.
 type CredentialsFileSource struct {
 	Username string
@@ -134,7 +145,7 @@ func (c *CredentialsGopassTotpSource) Resolve() (ResolvedCredentials, error) {
 	if err != nil {
 		return ResolvedCredentials{}, fmt.Errorf("failed to get username for: %s", c.Secret)
 	}
-	Totp, err := c.Api.GetOtp(c.Secret, c.timestampFn())
+	Totp, err := c.Api.GetOtp(c.Secret, c.GetTimestamp())
 	if err != nil {
 		return ResolvedCredentials{}, fmt.Errorf("failed to get totp for: %s. Reason: %+v", c.Secret, err)
 	}
@@ -215,57 +226,160 @@ type ResolvedCredentials struct {
 	Type CredentialSourceType
 }
 
+// Secrets lists every resolved value that must never be written anywhere.
+func (c ResolvedCredentials) Secrets() []string {
+	output := []string{}
+	for _, value := range []string{
+		c.UsernameAndPassword.Username,
+		c.UsernameAndPassword.Password,
+		c.UsernameAndPasswordAndTotp.Username,
+		c.UsernameAndPasswordAndTotp.Password,
+		c.UsernameAndPasswordAndTotp.Totp,
+	} {
+		if value != "" {
+			output = append(output, value)
+		}
+	}
+	return output
+}
+
+// SessionSecret is what saved sessions are encrypted with, it changes
+// whenever the username or password does.
+func (c ResolvedCredentials) SessionSecret() []byte {
+	username, password := c.UsernameAndPassword.Username, c.UsernameAndPassword.Password
+	if username == "" && password == "" {
+		username, password = c.UsernameAndPasswordAndTotp.Username, c.UsernameAndPasswordAndTotp.Password
+	}
+	return []byte(username + "\x00" + password)
+}
+
+// ResolvedUsername is whichever username was resolved.
+func (c ResolvedCredentials) ResolvedUsername() string {
+	if c.UsernameAndPassword.Username != "" {
+		return c.UsernameAndPassword.Username
+	}
+	return c.UsernameAndPasswordAndTotp.Username
+}
+
+// ResolvedPassword is whichever password was resolved.
+func (c ResolvedCredentials) ResolvedPassword() string {
+	if c.UsernameAndPassword.Password != "" {
+		return c.UsernameAndPassword.Password
+	}
+	return c.UsernameAndPasswordAndTotp.Password
+}
+
 type Credentials struct {
 	ResolvedCredentials
 	CredentialsSource
 	Type CredentialSourceType
 }
 
+// reads a string field from the raw credentials config. Keys are matched
+// case insensitively since the config reader lowercases nested keys.
+func getCredentialsField(source map[string]interface{}, key string) (string, error) {
+	for name, value := range source {
+		if !strings.EqualFold(name, key) {
+			continue
+		}
+		if output, ok := value.(string); ok {
+			return output, nil
+		}
+		return "", fmt.Errorf("%w: %s is not a string", core.ErrCredentialsUnresolved, key)
+	}
+	return "", fmt.Errorf("%w: missing %s", core.ErrCredentialsUnresolved, key)
+}
+
+// GetCredentialsType is the kind of credentials configured, without
+// resolving them.
+func GetCredentialsType(source map[string]interface{}) (CredentialSourceType, error) {
+	sourceType, err := getCredentialsField(source, "type")
+	return CredentialSourceType(sourceType), err
+}
+
 // accepts a generic object, inspects a key "type", and returns a struct with the embeded struct filled out.
-func NewCredentials(source map[string]interface{}) Credentials {
+func NewCredentials(source map[string]interface{}) (Credentials, error) {
 	var output Credentials
-	output.Type = CredentialSourceType(source["type"].(string))
+	var err error
+	fields := map[string]string{}
+
+	// collect the fields each credential type needs, failing on the first missing one
+	require := func(keys ...string) error {
+		for _, key := range keys {
+			value, err := getCredentialsField(source, key)
+			if err != nil {
+				return err
+			}
+			fields[key] = value
+		}
+		return nil
+	}
+
+	sourceType, err := getCredentialsField(source, "type")
+	if err != nil {
+		return output, err
+	}
+	output.Type = CredentialSourceType(sourceType)
 
-	switch source["type"] {
+	switch output.Type {
 	case CredentialSourceTypeFile:
+		if err = require("username", "password"); err != nil {
+			return output, err
+		}
 		output.CredentialsFileSource = CredentialsFileSource{
-			Username: source["username"].(string),
-			Password: source["password"].(string),
+			Username: fields["username"],
+			Password: fields["password"],
 		}
-		output.ResolvedCredentials, _ = output.CredentialsFileSource.Resolve()
+		output.ResolvedCredentials, err = output.CredentialsFileSource.Resolve()
 
 	case CredentialSourceTypeEnv:
+		if err = require("usernameKey", "passwordKey"); err != nil {
+			return output, err
+		}
 		output.CredentialsEnvSource = CredentialsEnvSource{
-			UsernameKey: source["usernameKey"].(string),
-			PasswordKey: source["passwordKey"].(string),
+			UsernameKey: fields["usernameKey"],
+			PasswordKey: fields["passwordKey"],
 		}
-		output.ResolvedCredentials, _ = output.CredentialsEnvSource.Resolve()
+		output.ResolvedCredentials, err = output.CredentialsEnvSource.Resolve()
 
 	case CredentialSourceTypeGopass:
+		if err = require("secret"); err != nil {
+			return output, err
+		}
 		output.CredentialsGopassSource = CredentialsGopassSource{
-			UsernameKey: source["usernameKey"].(string),
-			PasswordKey: source["passwordKey"].(string),
+			Secret: fields["secret"],
+			Api:    clients.NewGopassResolver(),
 		}
-		output.ResolvedCredentials, _ = output.CredentialsGopassSource.Resolve()
+		output.ResolvedCredentials, err = output.CredentialsGopassSource.Resolve()
 
 	case CredentialSourceTypeGopassTotp:
+		if err = require("secret"); err != nil {
+			return output, err
+		}
 		output.CredentialsGopassTotpSource = CredentialsGopassTotpSource{
-			UsernameKey: source["usernameKey"].(string),
-			PasswordKey: source["passwordKey"].(string),
-			TotpKey:     source["totpKey"].(string),
+			Secret: fields["secret"],
+			Api:    clients.NewGopassResolver(),
 		}
-		output.ResolvedCredentials, _ = output.CredentialsGopassTotpSource.Resolve()
+		output.ResolvedCredentials, err = output.CredentialsGopassTotpSource.Resolve()
 
 	case CredentialSourceTypeKeychain:
+		if err = require("serviceName", "username"); err != nil {
+			return output, err
+		}
 		output.CredentialsKeychainSource = CredentialsKeychainSource{
-			ServiceName: source["serviceName"].(string),
-			Username:    source["username"].(string),
+			ServiceName: fields["serviceName"],
+			Username:    fields["username"],
+			Api:         clients.NewKeychainResolver(),
 		}
-		output.ResolvedCredentials, _ = output.CredentialsKeychainSource.Resolve()
+		output.ResolvedCredentials, err = output.CredentialsKeychainSource.Resolve()
 
 	default:
-		panic(fmt.Sprintf("Unknown credential source type: %s", source["type"]))
+		return output, fmt.Errorf("%w: unknown credential source type: %s", core.ErrCredentialsUnresolved, sourceType)
 	}
 
-	return output
+	if err != nil {
+		return output, fmt.Errorf("%w: %w", core.ErrCredentialsUnresolved, err)
+	}
+
+	return output, nil
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/airtonix/bank-downloaders/core"
 	"github.com/airtonix/bank-downloaders/store/clients"
 	"github.com/stretchr/testify/assert"
 )
@@ -86,3 +87,29 @@ func TestKeychainCredentials(t *testing.T) {
 	assert.Equal(t, "someguy", resolved.UsernameAndPassword.Username)
 	assert.Equal(t, "somepassword", resolved.UsernameAndPassword.Password)
 }
+
+func TestNewCredentialsFromFileConfig(t *testing.T) {
+	credentials, err := NewCredentials(map[string]interface{}{
+		"type":     "file",
+		"username": "someguy",
+		"password": "somepassword",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, CredentialSourceTypeFile, credentials.Type)
+	assert.Equal(t, "someguy", credentials.UsernameAndPassword.Username)
+	assert.Equal(t, "somepassword", credentials.UsernameAndPassword.Password)
+}
+
+func TestNewCredentialsUnresolved(t *testing.T) {
+	_, err := NewCredentials(map[string]interface{}{
+		"type": "carrier-pigeon",
+	})
+	assert.ErrorIs(t, err, core.ErrCredentialsUnresolved)
+
+	_, err = NewCredentials(map[string]interface{}{
+		"type":     "file",
+		"username": "someguy",
+	})
+	assert.ErrorIs(t, err, core.ErrCredentialsUnresolved)
+}
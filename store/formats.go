@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/airtonix/bank-downloaders/meta"
+)
+
+// how long formats read from a bank's site are trusted for
+const formatCacheMaxAge = 30 * 24 * time.Hour
+
+// the formats read from one bank's site
+type formatCacheEntry struct {
+	Formats []string  `json:"formats"`
+	Read    time.Time `json:"read"`
+}
+
+// FormatCache remembers the export formats each bank offered when they were
+// last read from its site, so configured formats can be checked before a
+// browser is started.
+type FormatCache struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFormatCache keeps formats in the file at path, or in the user cache
+// directory when path is empty.
+func NewFormatCache(path string) (*FormatCache, error) {
+	if path == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not get user cache directory: %w", err)
+		}
+		path = filepath.Join(cacheDir, meta.Name, "formats.json")
+	}
+	return &FormatCache{Path: path}, nil
+}
+
+// the same bank can be reached at more than one domain, eg: a test site
+func formatCacheKey(sourceType SourceType, domain string) string {
+	return string(sourceType) + " " + domain
+}
+
+// read by callers holding mu, a missing file is an empty cache
+func (c *FormatCache) load() (map[string]formatCacheEntry, error) {
+	entries := map[string]formatCacheEntry{}
+	content, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", c.Path, err)
+	}
+	return entries, nil
+}
+
+// Get returns the formats last read from the site of sourceType at domain,
+// unless there are none or they are too old to trust.
+func (c *FormatCache) Get(sourceType SourceType, domain string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.load()
+	if err != nil {
+		return nil, false
+	}
+	entry, ok := entries[formatCacheKey(sourceType, domain)]
+	if !ok || time.Since(entry.Read) > formatCacheMaxAge {
+		return nil, false
+	}
+	return entry.Formats, true
+}
+
+// Save remembers the formats just read from the site of sourceType at
+// domain.
+func (c *FormatCache) Save(sourceType SourceType, domain string, formats []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.load()
+	if err != nil {
+		// a broken cache is replaced
+		entries = map[string]formatCacheEntry{}
+	}
+	entries[formatCacheKey(sourceType, domain)] = formatCacheEntry{
+		Formats: formats,
+		Read:    time.Now(),
+	}
+
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0700); err != nil {
+		return fmt.Errorf("could not save formats: %w", err)
+	}
+	if err := os.WriteFile(c.Path, content, 0600); err != nil {
+		return fmt.Errorf("could not save formats: %w", err)
+	}
+	return nil
+}
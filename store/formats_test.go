@@ -0,0 +1,42 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatCacheRoundTrip(t *testing.T) {
+	cache, err := NewFormatCache(filepath.Join(t.TempDir(), "cache", "formats.json"))
+	assert.NoError(t, err)
+
+	_, ok := cache.Get(AnzSourceType, "https://bank.example")
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Save(AnzSourceType, "https://bank.example", []string{"CSV", "MYOB(OFX)"}))
+	formats, ok := cache.Get(AnzSourceType, "https://bank.example")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"CSV", "MYOB(OFX)"}, formats)
+
+	_, ok = cache.Get(AnzSourceType, "https://test.bank.example")
+	assert.False(t, ok)
+}
+
+func TestFormatCacheIgnoresOldFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "formats.json")
+	content, _ := json.Marshal(map[string]formatCacheEntry{
+		formatCacheKey(AnzSourceType, "https://bank.example"): {
+			Formats: []string{"CSV"},
+			Read:    time.Now().Add(-formatCacheMaxAge - time.Hour),
+		},
+	})
+	assert.NoError(t, os.WriteFile(path, content, 0600))
+
+	cache, _ := NewFormatCache(path)
+	_, ok := cache.Get(AnzSourceType, "https://bank.example")
+	assert.False(t, ok)
+}
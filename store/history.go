@@ -11,6 +11,7 @@ import (
 
 	"errors"
 	"sort"
+	"sync"
 	"time"
 
 	"dario.cat/mergo"
@@ -27,12 +28,18 @@ type History struct {
 	Events []HistoryEvent
 }
 
+// guards the events of the history, sources downloading side by side
+// read and save them at the same time
+var historyMu sync.Mutex
+
 func (h *History) GetEvents(
 	sourceType SourceType,
 	accountNo string,
 ) []HistoryEvent {
 	events := []HistoryEvent{}
 
+	historyMu.Lock()
+	defer historyMu.Unlock()
 	for _, event := range h.Events {
 		if event.Source == sourceType && event.AccountNumber == accountNo {
 
@@ -86,13 +93,16 @@ func (h *History) GetLatestEvent(
 //   - `since-last-download`: `from` always the last downloaded transaction `lastDateFetched`, and `to` is `lastDateFetched` plus `daysToFetch` days.
 //     If `lastDateFetched` is not available, it will default to `days-ago`.
 //     If `lastDateFetched` plus `daysToFetch` is beyond yesterday, it will default to yeserday.
+//
+// Days are counted in location, the timezone of the source.
 func (h *History) GetDownloadDateRange(
 	sourceType SourceType,
 	accountNo string,
 	daysToFetch int,
 	strategy HistoryStrategy,
+	location *time.Location,
 ) (time.Time, time.Time, error) {
-	toDate := core.GetTodayMinusDays(1)
+	toDate := core.GetTodayMinusDaysIn(location, 1)
 	fromDate := core.GetDaysAgo(toDate, daysToFetch)
 
 	// Strategy: DaysAgo
@@ -115,10 +125,10 @@ func (h *History) GetDownloadDateRange(
 		}
 
 		// fromDate will be the last toDate
-		fromDate := core.StringToDate(event.LastDateFetched, time.RFC3339)
+		fromDate := core.StringToDateIn(event.LastDateFetched, time.RFC3339, location)
 		// toDate will be fromDate plus daysToFetch
 		toDate = fromDate.AddDate(0, 0, daysToFetch)
-		yesterday := core.GetTodayMinusDays(1)
+		yesterday := core.GetTodayMinusDaysIn(location, 1)
 
 		// if toDate is beyond yesterday, default to yesterday
 		if toDate.After(yesterday) {
@@ -147,6 +157,8 @@ func (h *History) SaveEvent(
 		AccountNumber:   accountNo,
 		LastDateFetched: toDate.Format(time.RFC3339),
 	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
 	h.Events = append(h.Events, event)
 	h.Save()
 }
@@ -245,6 +257,8 @@ func CreateNewHistoryFile() {
 func InitHistory(configFileArg string) {
 	historyReader = NewHistoryReader(configFileArg)
 	err := historyReader.Unmarshal(&history)
-	core.AssertErrorToNilf("could not unmarshal history: %w", err)
+	if core.AssertErrorToNilf("could not unmarshal history: %w", err) {
+		logrus.Fatal(err)
+	}
 	logrus.Debugln("history file", historyReader.ConfigFileUsed())
 }
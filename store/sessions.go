@@ -0,0 +1,160 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/airtonix/bank-downloaders/meta"
+	"golang.org/x/crypto/scrypt"
+)
+
+// bump when the layout of a session file changes, older files are ignored
+const sessionFileVersion = 1
+
+// a session file on disk, the state is only readable with the credentials
+// that created it
+type sessionFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// SessionStore keeps the logged in browser state of each source, encrypted
+// with a key derived from the source's credentials.
+type SessionStore struct {
+	Dir string
+}
+
+// NewSessionStore keeps sessions in dir, or the user cache directory when
+// dir is empty.
+func NewSessionStore(dir string) (*SessionStore, error) {
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not get user cache directory: %w", err)
+		}
+		dir = filepath.Join(cacheDir, meta.Name, "sessions")
+	}
+	return &SessionStore{Dir: dir}, nil
+}
+
+// SessionName names the session of one login to a source, without
+// revealing the username.
+func SessionName(sourceType SourceType, domain string, username string) string {
+	sum := sha256.Sum256([]byte(domain + "\x00" + username))
+	return fmt.Sprintf("%s-%x", core.Slugify(string(sourceType)), sum[:8])
+}
+
+func (s *SessionStore) path(name string) string {
+	return filepath.Join(s.Dir, name+".session")
+}
+
+func sessionKey(secret []byte, salt []byte) ([]byte, error) {
+	return scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+}
+
+func sessionCipher(secret []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := sessionKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts the state with secret and writes it under name.
+func (s *SessionStore) Save(name string, secret []byte, state *core.SessionState) error {
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+
+	file := sessionFile{
+		Version: sessionFileVersion,
+		Salt:    make([]byte, 16),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+	aead, err := sessionCipher(secret, file.Salt)
+	if err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plaintext, []byte(name))
+
+	content, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("could not create session directory: %w", err)
+	}
+
+	// write next to the old file and swap, so a crash never leaves half a session
+	path := s.path(name)
+	if err := os.WriteFile(path+".tmp", content, 0600); err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Load reads and decrypts the state saved under name. A session that was
+// never saved is reported as os.ErrNotExist, one that can't be decrypted
+// as core.ErrSessionExpired.
+func (s *SessionStore) Load(name string, secret []byte) (*core.SessionState, error) {
+	content, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var file sessionFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+	if file.Version != sessionFileVersion {
+		return nil, fmt.Errorf("%w: unknown session file version %d", core.ErrSessionExpired, file.Version)
+	}
+
+	aead, err := sessionCipher(secret, file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed session file", core.ErrSessionExpired)
+	}
+	// fails when the credentials changed since the session was saved
+	plaintext, err := aead.Open(nil, file.Nonce, file.Data, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decrypt session", core.ErrSessionExpired)
+	}
+
+	var state core.SessionState
+	if err := json.Unmarshal(plaintext, &state); err != nil {
+		return nil, fmt.Errorf("%w: %w", core.ErrSessionExpired, err)
+	}
+	return &state, nil
+}
+
+// Forget removes the session saved under name, if there is one.
+func (s *SessionStore) Forget(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
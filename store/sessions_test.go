@@ -0,0 +1,49 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/airtonix/bank-downloaders/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStoreRoundTrip(t *testing.T) {
+	sessions, err := NewSessionStore(t.TempDir())
+	assert.NoError(t, err)
+	name := SessionName(AnzSourceType, "https://bank.example", "someguy")
+	state := &core.SessionState{
+		URL:          "https://bank.example/internetbanking",
+		Origin:       "https://bank.example",
+		Cookies:      []core.SessionCookie{{Name: "session", Value: "abc123"}},
+		LocalStorage: map[string]string{"token": "xyz"},
+	}
+
+	assert.NoError(t, sessions.Save(name, []byte("someguy\x00secret"), state))
+
+	content, err := os.ReadFile(sessions.path(name))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "abc123")
+	assert.NotContains(t, string(content), "someguy")
+
+	loaded, err := sessions.Load(name, []byte("someguy\x00secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, state.URL, loaded.URL)
+	assert.Equal(t, "abc123", loaded.Cookies[0].Value)
+	assert.Equal(t, "xyz", loaded.LocalStorage["token"])
+}
+
+func TestSessionStoreRejectsChangedCredentials(t *testing.T) {
+	sessions, err := NewSessionStore(t.TempDir())
+	assert.NoError(t, err)
+
+	_, err = sessions.Load("anz-missing", []byte("secret"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.NoError(t, sessions.Save("anz-test", []byte("old password"), &core.SessionState{}))
+	_, err = sessions.Load("anz-test", []byte("new password"))
+	assert.ErrorIs(t, err, core.ErrSessionExpired)
+
+	assert.NoError(t, sessions.Forget("anz-test"))
+	assert.NoError(t, sessions.Forget("anz-test"))
+}